@@ -0,0 +1,141 @@
+package oyster
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sourcegraph/rwvfs"
+)
+
+const (
+	attachmentDir = ".attachments"
+)
+
+var (
+	ErrInvalidAttachment = errors.New("Invalid attachment name")
+)
+
+// Attachment describes a binary secret stored with a form. Size is the
+// encrypted size on disk which is slightly larger than the content.
+type Attachment struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type AttachmentSlice []Attachment
+
+func (p AttachmentSlice) Len() int           { return len(p) }
+func (p AttachmentSlice) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p AttachmentSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Attach encrypts everything read from r into key, returning the number of
+// bytes read. Content is streamed and never held in memory as a whole.
+func (r *FileRepo) Attach(key string, src io.Reader) (int64, error) {
+	plaintext, err := r.Create(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(plaintext, src)
+	if err != nil {
+		plaintext.Close()
+		return n, err
+	}
+	return n, plaintext.Close()
+}
+
+// Extract decrypts key into dst, returning the number of bytes written.
+func (r *FileRepo) Extract(key string, dst io.Writer, passphrase []byte) (int64, error) {
+	plaintext, err := r.Open(key, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	defer plaintext.Close()
+	return io.Copy(dst, plaintext)
+}
+
+func (r *FormRepo) attachmentName(key, name string) string {
+	return r.fs.Join(key, attachmentDir, name+fileExtension)
+}
+
+func (r *FormRepo) Attachments(key string) (AttachmentSlice, error) {
+	attachments := make(AttachmentSlice, 0)
+	fileinfos, err := r.fs.ReadDir(r.fs.Join(key, attachmentDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return attachments, nil
+		}
+		return nil, err
+	}
+	for _, fileinfo := range fileinfos {
+		filename := fileinfo.Name()
+		if fileinfo.IsDir() || isHidden(filename) || filepath.Ext(filename) != fileExtension {
+			continue
+		}
+		attachments = append(attachments, Attachment{
+			Name: filename[:len(filename)-len(fileExtension)],
+			Size: fileinfo.Size(),
+		})
+	}
+	sort.Sort(attachments)
+	return attachments, nil
+}
+
+// Attach encrypts everything read from src as the attachment name of the
+// form at key, returning the number of bytes read.
+func (r *FormRepo) Attach(key, name string, src io.Reader) (int64, error) {
+	if !validFieldName(name) {
+		return 0, ErrInvalidAttachment
+	}
+	if err := rwvfs.MkdirAll(r.fs, r.fs.Join(key, attachmentDir)); err != nil {
+		return 0, err
+	}
+	plaintext, err := r.fs.CreateEncrypted(r.attachmentName(key, name))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(plaintext, src)
+	if err != nil {
+		plaintext.Close()
+		return n, err
+	}
+	if err := plaintext.Close(); err != nil {
+		return n, err
+	}
+	return n, r.fs.touchMeta(formMeta(key))
+}
+
+func (r *FormRepo) OpenAttachment(key, name string, passphrase []byte) (io.ReadCloser, error) {
+	if !validFieldName(name) {
+		return nil, ErrInvalidAttachment
+	}
+	return r.fs.OpenEncrypted(r.attachmentName(key, name), passphrase)
+}
+
+func (r *FormRepo) RemoveAttachment(key, name string) error {
+	if !validFieldName(name) {
+		return ErrInvalidAttachment
+	}
+	if err := r.fs.Remove(r.attachmentName(key, name)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *FormRepo) removeAttachments(key string) error {
+	attachments, err := r.Attachments(key)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if err := r.RemoveAttachment(key, attachment.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
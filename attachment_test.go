@@ -0,0 +1,116 @@
+package oyster
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+const testAttachmentSize = 5<<20 + 123
+
+func testPayload() io.Reader {
+	return io.LimitReader(rand.New(rand.NewSource(1)), testAttachmentSize)
+}
+
+func testPayloadSum(t testing.TB) []byte {
+	h := sha256.New()
+	if _, err := io.Copy(h, testPayload()); err != nil {
+		t.Fatal(err)
+	}
+	return h.Sum(nil)
+}
+
+func TestFileRepoAttachExtract(t *testing.T) {
+	repo := setupFileRepo(t)
+
+	n, err := repo.Attach("ssh/id_rsa", testPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != testAttachmentSize {
+		t.Errorf("Expected %d bytes attached, got %d", testAttachmentSize, n)
+	}
+
+	h := sha256.New()
+	n, err = repo.Extract("ssh/id_rsa", h, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != testAttachmentSize {
+		t.Errorf("Expected %d bytes extracted, got %d", testAttachmentSize, n)
+	}
+	if !bytes.Equal(h.Sum(nil), testPayloadSum(t)) {
+		t.Error("Extracted content does not match")
+	}
+}
+
+func TestFormRepoAttachments(t *testing.T) {
+	repo := setupFormRepo(t)
+	loadTestForms(t, repo)
+
+	if _, err := repo.Attach("example.com", "recovery-codes.pdf", testPayload()); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := repo.Fields("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Fields) != 2 {
+		t.Errorf("Expected 2 fields, got %#v", form.Fields)
+	}
+	if len(form.Attachments) != 1 || form.Attachments[0].Name != "recovery-codes.pdf" {
+		t.Fatalf("Expected 'recovery-codes.pdf' attachment, got %#v", form.Attachments)
+	}
+	if form.Attachments[0].Size < testAttachmentSize {
+		t.Errorf("Expected size of at least %d, got %d", testAttachmentSize, form.Attachments[0].Size)
+	}
+
+	forms, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != len(testKeys) {
+		t.Fatalf("Expected %d forms, got %d", len(testKeys), len(forms))
+	}
+
+	plaintext, err := repo.OpenAttachment("example.com", "recovery-codes.pdf", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, plaintext); err != nil {
+		t.Fatal(err)
+	}
+	plaintext.Close()
+	if !bytes.Equal(h.Sum(nil), testPayloadSum(t)) {
+		t.Error("Attachment content does not match")
+	}
+
+	if err := repo.Remove("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.OpenAttachment("example.com", "recovery-codes.pdf", []byte("password")); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestFormRepoAttachInvalidName(t *testing.T) {
+	repo := setupFormRepo(t)
+	loadTestForms(t, repo)
+
+	for _, name := range []string{"", ".", "..", "../x", "a/b", ".hidden"} {
+		if _, err := repo.Attach("example.com", name, strings.NewReader("secret")); err != ErrInvalidAttachment {
+			t.Errorf("Expected ErrInvalidAttachment for %#v, got %v", name, err)
+		}
+		if _, err := repo.OpenAttachment("example.com", name, []byte("password")); err != ErrInvalidAttachment {
+			t.Errorf("Expected ErrInvalidAttachment for %#v, got %v", name, err)
+		}
+		if err := repo.RemoveAttachment("example.com", name); err != ErrInvalidAttachment {
+			t.Errorf("Expected ErrInvalidAttachment for %#v, got %v", name, err)
+		}
+	}
+}
@@ -6,24 +6,61 @@ var app = require('angular').module('oyster', [
 
 app.factory("FormRepo", FormRepo);
 
+var CONFIRMATION_REQUIRED = "CONFIRMATION_REQUIRED";
+var INVALID_SESSION = "INVALID_SESSION";
+
 function FormRepo($q, Runtime) {
+  // status resolves with whether the keys are unlocked and whether the
+  // host asks for the passphrase itself with pinentry.
+  function status() {
+    return sendMessage({type: "STATUS"});
+  }
+
+  function unlock(password) {
+    return sendMessage({
+      type: "UNLOCK",
+      data: {passphrase: password}
+    });
+  }
+
+  function lock() {
+    return sendMessage({type: "LOCK"});
+  }
+
+  // Requests from the options page are not for filling any page.
+  var OPTIONS_PAGE = {options: true};
+
   function list() {
-    return sendMessage({type: "LIST"});
+    return sendMessage({
+      type: "LIST",
+      data: OPTIONS_PAGE
+    });
   }
 
-  function search(q) {
+  function search(url) {
     return sendMessage({
       type: "SEARCH",
-      data: {query: q}
+      data: {query: url, url: url}
     });
   }
 
-  function get(key, password) {
+  function find(term, url) {
+    return sendMessage({
+      type: "FIND",
+      data: {query: term, url: url}
+    });
+  }
+
+  // get decrypts with the session from unlock. origin is the page being
+  // filled, {url: url}, or OPTIONS_PAGE.
+  function get(key, origin, confirmed) {
     return sendMessage({
       type: "GET",
       data: {
         key: key,
-        passphrase: password
+        url: origin.url,
+        options: origin.options,
+        confirmed: confirmed
       }
     });
   }
@@ -55,17 +92,19 @@ function FormRepo($q, Runtime) {
   }
 
   function sendMessage(msg) {
-    return $q(function(resolve, reject) {
-      Runtime.sendNativeMessage('com.github.proglottis.oyster', msg).then(function(response) {
-        if (response.type === "ERROR") {
-          return reject(response.data);
-        }
-        resolve(response.data);
-      }, reject);
+    return Runtime.sendMessage(msg);
+  }
+
+  // unlocked unlocks the keys with password unless they already are.
+  function unlocked(password) {
+    return status().then(function(s) {
+      if (!s.unlocked) {
+        return unlock(password);
+      }
     });
   }
 
-  return {list: list, search: search, get: get, put: put, destroy: destroy, update: update};
+  return {OPTIONS_PAGE: OPTIONS_PAGE, status: status, unlock: unlock, unlocked: unlocked, lock: lock, list: list, search: search, find: find, get: get, put: put, destroy: destroy, update: update};
 }
 
 app.controller("NewFormCtrl", NewFormCtrl);
@@ -105,35 +144,69 @@ app.controller("FormSearchCtrl", FormSearchCtrl);
 
 function FormSearchCtrl($scope, Tabs, FormRepo, $window) {
   $scope.password = "";
+  // With pinentry the host asks for the passphrase itself.
+  var ready = FormRepo.status().then(function(status) {
+    $scope.pinentry = status.pinentry;
+    $scope.unlocked = status.unlocked;
+  });
   Tabs.getCurrentActive().then(function(tab) {
     $scope.tabId = tab.id;
+    $scope.url = tab.url;
     FormRepo.search(tab.url).then(function(forms) {
       $scope.forms = forms;
       if($scope.forms.length < 1) {
         $scope.message = "No saved forms for this page";
       }
     }, function(err) {
-      $scope.message = err;
+      $scope.message = err.message;
     });
   });
 
+  $scope.find = function() {
+    if (!$scope.query) {
+      return;
+    }
+    FormRepo.find($scope.query, $scope.url).then(function(forms) {
+      $scope.forms = forms;
+      $scope.message = null;
+    }, function(err) {
+      $scope.message = err.message;
+    });
+  };
+
   $scope.select = function(form) {
     $scope.selectedForm = form;
+    ready.then(function() {
+      if ($scope.pinentry || $scope.unlocked) {
+        $scope.unlock();
+      }
+    });
   };
 
   $scope.unselect = function() {
     $scope.selectedForm = null;
   };
 
-  $scope.unlock = function() {
-    FormRepo.get($scope.selectedForm.key, $scope.password).then(function(forms) {
+  $scope.unlock = function(confirmed) {
+    FormRepo.unlocked($scope.password).then(function() {
+      $scope.password = "";
+      return FormRepo.get($scope.selectedForm.key, {url: $scope.url}, confirmed);
+    }).then(function(forms) {
       Tabs.sendMessage($scope.tabId, {
         type: "SET_FORM",
         data: forms
       });
       $scope.close();
     }, function(err) {
-      $scope.message = err;
+      if (!confirmed && err.code === CONFIRMATION_REQUIRED &&
+          $window.confirm($scope.selectedForm.key + " was saved for another site. Fill this page anyway?")) {
+        $scope.unlock(true);
+        return;
+      }
+      if (err.code === INVALID_SESSION) {
+        $scope.unlocked = false;
+      }
+      $scope.message = err.message;
     });
   };
 
@@ -154,7 +227,7 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
       $scope.message = "No saved forms";
     }
   }, function(err) {
-    $scope.message = err;
+    $scope.message = err.message;
   });
 
   $scope.new = function() {
@@ -170,6 +243,14 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
     $scope.selectedForm = form;
 
     $scope.unlocked = false;
+    // Nothing to ask for when the host prompts itself or the keys are
+    // still unlocked.
+    FormRepo.status().then(function(status) {
+      $scope.pinentry = status.pinentry;
+      if ($scope.selectedForm === form && (status.pinentry || status.unlocked)) {
+        $scope.unlock();
+      }
+    });
   };
 
   $scope.save = function() {
@@ -184,7 +265,7 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
       $scope.forms[index] = $scope.selectedForm;
       $scope.message = null;
     }, function(err) {
-      $scope.message = err;
+      $scope.message = err.message;
     }).finally(function() {
       $scope.cancel();
     });
@@ -197,7 +278,7 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
       FormRepo.destroy(form.key).then(function() {
         $scope.forms.splice(index, 1);
       }, function(err) {
-        $scope.message = err;
+        $scope.message = err.message;
       });
     }
   };
@@ -222,7 +303,9 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
   };
 
   $scope.unlock = function() {
-    FormRepo.get($scope.selectedForm.key, $scope.password).then(function(form) {
+    FormRepo.unlocked($scope.password).then(function() {
+      return FormRepo.get($scope.selectedForm.key, FormRepo.OPTIONS_PAGE);
+    }).then(function(form) {
       if (form) {
         $scope.selectedForm = form;
 
@@ -233,7 +316,7 @@ function FormListCtrl($scope, FormRepo, $window, $log) {
 
       $scope.message = null;
     }, function(err) {
-      $scope.message = err;
+      $scope.message = err.message;
     });
 
     $scope.password = "";
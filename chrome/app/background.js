@@ -11,12 +11,43 @@ function urlKey(url) {
     return key;
 }
 
+// captures holds the fields last saved from each tab. Saving different
+// fields from the same site again adds them as the next step of the same
+// form, for sites that ask for the username and password on separate
+// pages.
+var captures = {};
+
+function sharesFields(a, b) {
+  return a.some(function(x) {
+    return b.some(function(y) {
+      return x.name === y.name;
+    });
+  });
+}
+
+function captureForm(tabId, url, fields) {
+  var host = new Uri(url).host();
+  var previous = captures[tabId];
+  var capture = {host: host, key: urlKey(url), step: 1, fields: fields};
+  if (previous && previous.host === host && !sharesFields(previous.fields, fields)) {
+    capture.key = previous.key;
+    capture.step = previous.step + 1;
+    capture.fields = previous.fields.concat(fields);
+  }
+  fields.forEach(function(field) {
+    field.step = capture.step;
+  });
+  captures[tabId] = capture;
+  return capture;
+}
+
 function newFormPopup(tabId, url) {
   chrome.tabs.sendMessage(tabId, {type: "GET_FORM"}, function(fields) {
+    var capture = captureForm(tabId, url, fields);
     var form = {
       tabId: tabId,
-      key: urlKey(url),
-      fields: fields
+      key: capture.key,
+      fields: capture.fields
     };
     chrome.windows.create({url: 'newform.html', type: 'popup', width: 400, height: 500}, function(){
       chrome.runtime.sendMessage(form);
@@ -24,6 +55,10 @@ function newFormPopup(tabId, url) {
   });
 }
 
+chrome.tabs.onRemoved.addListener(function(tabId) {
+  delete captures[tabId];
+});
+
 chrome.contextMenus.create({
   title: 'Save Page Fields',
   contexts: ['all'],
@@ -31,3 +66,135 @@ chrome.contextMenus.create({
     newFormPopup(tab.id, tab.url);
   }
 });
+
+// The native host is kept running on one port so that it can negotiate the
+// protocol version and keep the keys unlocked between popups. Pages send
+// their requests here wrapped as {host: request}.
+var HOST = 'com.github.proglottis.oyster';
+var INVALID_SESSION = "INVALID_SESSION";
+
+var port = null;
+var ready = null;
+var nextId = 1;
+var pending = {};
+var session = null;
+
+function disconnected() {
+  var message = chrome.runtime.lastError ? chrome.runtime.lastError.message : "Oyster host disconnected";
+  Object.keys(pending).forEach(function(id) {
+    pending[id].reject({code: "IO", message: message});
+  });
+  port = null;
+  ready = null;
+  pending = {};
+  session = null;
+}
+
+function received(msg) {
+  var request = pending[msg.id];
+  if (!request) {
+    return;
+  }
+  if (msg.type === "ERROR") {
+    delete pending[msg.id];
+    request.reject(msg.data);
+    return;
+  }
+  if (msg.pages) {
+    request.pages = (request.pages || []).concat(msg.data);
+    if (msg.page < msg.pages) {
+      return;
+    }
+    msg.data = request.pages;
+  }
+  delete pending[msg.id];
+  request.resolve(msg.data);
+}
+
+function post(type, data) {
+  return new Promise(function(resolve, reject) {
+    var id = nextId++;
+    pending[id] = {resolve: resolve, reject: reject};
+    port.postMessage({id: id, type: type, data: data});
+  });
+}
+
+// connect starts the host when needed and resolves with its HELLO.
+function connect() {
+  if (!ready) {
+    port = chrome.runtime.connectNative(HOST);
+    port.onMessage.addListener(received);
+    port.onDisconnect.addListener(disconnected);
+    ready = post("HELLO", {version: 2});
+  }
+  return ready;
+}
+
+function unlock(passphrase) {
+  return post("UNLOCK", {passphrase: passphrase}).then(function(data) {
+    var current = session = {token: data.token};
+    setTimeout(function() {
+      if (session === current) {
+        session = null;
+      }
+    }, data.ttl * 1000);
+  });
+}
+
+function lock() {
+  var current = session;
+  session = null;
+  if (!current) {
+    return Promise.resolve();
+  }
+  return post("LOCK", {token: current.token});
+}
+
+// withSession sends a request that decrypts with the session token. An
+// expired session is unlocked again when the host has its own pinentry,
+// otherwise the page has to ask for the passphrase again.
+function withSession(type, data, hello) {
+  data.token = session ? session.token : undefined;
+  return post(type, data).catch(function(err) {
+    if (err.code !== INVALID_SESSION || !data.token) {
+      throw err;
+    }
+    session = null;
+    if (!hello.pinentry) {
+      throw err;
+    }
+    return unlock("").then(function() {
+      data.token = session.token;
+      return post(type, data);
+    });
+  });
+}
+
+function handle(request) {
+  return connect().then(function(hello) {
+    switch (request.type) {
+    case "STATUS":
+      return {unlocked: !!session, pinentry: !!hello.pinentry};
+    case "UNLOCK":
+      return unlock(request.data.passphrase);
+    case "LOCK":
+      return lock();
+    case "GET":
+    case "SEARCH":
+      return withSession(request.type, request.data, hello);
+    }
+    return post(request.type, request.data);
+  });
+}
+
+chrome.runtime.onMessage.addListener(function(message, sender, sendResponse) {
+  if (!message.host) {
+    return false;
+  }
+  handle(message.host).then(function(data) {
+    sendResponse({data: data});
+  }, function(err) {
+    sendResponse({error: err});
+  });
+  return true;
+});
@@ -40,14 +40,20 @@ function Tabs($q) {
 c.factory("Runtime", Runtime);
 
 function Runtime($q) {
-  function sendNativeMessage(application, message) {
+  // sendMessage passes a request to the native host through the
+  // background page, which keeps the host running between requests.
+  function sendMessage(message) {
     return $q(function(resolve, reject) {
-      chrome.runtime.sendNativeMessage(application, message, function(response) {
+      chrome.runtime.sendMessage({host: message}, function(response) {
         if (chrome.runtime.lastError) {
-          reject(chrome.runtime.lastError.message);
+          reject({message: chrome.runtime.lastError.message});
           return;
         }
-        resolve(response);
+        if (response.error) {
+          reject(response.error);
+          return;
+        }
+        resolve(response.data);
       });
     });
   }
@@ -55,6 +61,9 @@ function Runtime($q) {
   function receive() {
     return $q(function(resolve, reject) {
       chrome.runtime.onMessage.addListener(function(message, sender, sendResponse) {
+        if (message.host) {
+          return;
+        }
         if (chrome.runtime.lastError) {
           reject(chrome.runtime.lastError.message);
           return;
@@ -64,5 +73,5 @@ function Runtime($q) {
     });
   }
 
-  return {sendNativeMessage: sendNativeMessage, receive: receive};
+  return {sendMessage: sendMessage, receive: receive};
 }
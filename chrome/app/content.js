@@ -1,10 +1,49 @@
 'use strict';
 
-function setElementsValueByName(name, value) {
-  var elements = document.getElementsByName(name);
+function findElements(field) {
+  if(field.selector) {
+    try {
+      return document.querySelectorAll(field.selector);
+    } catch(e) {
+      // Fall back to the name for selectors this page cannot parse
+    }
+  }
+  return document.getElementsByName(field.name);
+}
+
+function setFieldValue(field) {
+  var elements = findElements(field);
   for(var i = 0; i < elements.length; i++) {
-    elements[i].value = value;
+    elements[i].value = field.value;
+  }
+}
+
+// currentStep is the first step of a multi-step login with a field on
+// this page. Fields of other steps are left for the pages that ask for them.
+function currentStep(fields) {
+  var step = 0;
+  fields.forEach(function(field) {
+    if(field.step && (!step || field.step < step) && findElements(field).length > 0) {
+      step = field.step;
+    }
+  });
+  return step;
+}
+
+function setFormValues(form) {
+  var step = currentStep(form.fields);
+  form.fields.forEach(function(field) {
+    if(!field.step || field.step === step) {
+      setFieldValue(field);
+    }
+  });
+}
+
+function elementSelector(el) {
+  if(el.id) {
+    return '#' + CSS.escape(el.id);
   }
+  return '';
 }
 
 function serializeForms() {
@@ -13,10 +52,16 @@ function serializeForms() {
     var elements = document.forms[i].elements;
     for(var j = 0; j < elements.length; j++) {
       var el = elements[j];
-      if(!el.name || el.clientHeight < 1 || el.clientWidth < 1) {
+      if(!(el.name || el.id) || el.clientHeight < 1 || el.clientWidth < 1) {
         continue;
       }
-      fields.push({name: el.name, value: el.value});
+      fields.push({
+        name: el.name || el.id,
+        value: el.value,
+        type: el.type,
+        selector: elementSelector(el),
+        sensitive: el.type === "password"
+      });
     }
   }
   return fields;
@@ -25,9 +70,7 @@ function serializeForms() {
 chrome.runtime.onMessage.addListener(function (message, sender, sendResponse) {
   switch(message.type) {
   case "SET_FORM":
-    message.data.fields.forEach(function(field) {
-      setElementsValueByName(field.name, field.value);
-    });
+    setFormValues(message.data);
     break;
   case "GET_FORM":
     sendResponse(serializeForms());
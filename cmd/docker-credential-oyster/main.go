@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/proglottis/oyster"
+	"github.com/sourcegraph/rwvfs"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	keyPrefix     = "docker"
+	usernameField = "username"
+)
+
+var (
+	// ErrCredentialsNotFound must read exactly so, docker matches on it.
+	ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+	ErrMissingServerURL    = errors.New("no credentials server URL")
+	ErrMissingUsername     = errors.New("no credentials username")
+	ErrUnknownAction       = errors.New("Unknown action")
+)
+
+// Credentials is the document exchanged with docker by credential helpers.
+type Credentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// serverKey is the form key holding the credentials of a server. The URL is
+// escaped into a single component so that it can be listed back exactly.
+func serverKey(serverURL string) string {
+	return keyPrefix + "/" + url.QueryEscape(serverURL)
+}
+
+// credentialKey finds the login stored for serverURL.
+func credentialKey(repo *oyster.FormRepo, serverURL string) (string, error) {
+	logins, err := repo.Logins(serverKey(serverURL))
+	if err != nil {
+		return "", err
+	}
+	if len(logins) < 1 {
+		return "", ErrCredentialsNotFound
+	}
+	return logins[0], nil
+}
+
+func readServerURL(r io.Reader) (string, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	serverURL := strings.TrimSpace(string(buf))
+	if serverURL == "" {
+		return "", ErrMissingServerURL
+	}
+	return serverURL, nil
+}
+
+func store(repo *oyster.FormRepo, r io.Reader) error {
+	var creds Credentials
+	if err := json.NewDecoder(r).Decode(&creds); err != nil {
+		return err
+	}
+	if creds.ServerURL == "" {
+		return ErrMissingServerURL
+	}
+	// Logins are listed back by username, so one without cannot be found.
+	if creds.Username == "" {
+		return ErrMissingUsername
+	}
+	if err := erase(repo, strings.NewReader(creds.ServerURL)); err != nil && err != ErrCredentialsNotFound {
+		return err
+	}
+	return repo.Put(&oyster.Form{
+		Key: oyster.LoginKey(serverKey(creds.ServerURL), creds.Username),
+		Fields: []oyster.Field{
+			oyster.Field{Name: oyster.PasswordField, Value: creds.Secret, Sensitive: true},
+			oyster.Field{Name: usernameField, Value: creds.Username},
+		},
+	})
+}
+
+func get(repo *oyster.FormRepo, r io.Reader, w io.Writer, passphrase func() ([]byte, error)) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	key, err := credentialKey(repo, serverURL)
+	if err != nil {
+		return err
+	}
+	p, err := passphrase()
+	if err != nil {
+		return err
+	}
+	form, err := repo.Get(key, p)
+	if err != nil {
+		return err
+	}
+	creds := Credentials{ServerURL: serverURL}
+	for _, field := range form.Fields {
+		switch field.Name {
+		case usernameField:
+			creds.Username = field.Value
+		case oyster.PasswordField:
+			creds.Secret = field.Value
+		}
+	}
+	return json.NewEncoder(w).Encode(creds)
+}
+
+func erase(repo *oyster.FormRepo, r io.Reader) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	logins, err := repo.Logins(serverKey(serverURL))
+	if err != nil {
+		return err
+	}
+	if len(logins) < 1 {
+		return ErrCredentialsNotFound
+	}
+	for _, key := range logins {
+		if err := repo.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list maps each server URL to its username, read from the keys so that no
+// passphrase is needed.
+func list(repo *oyster.FormRepo, w io.Writer) error {
+	forms, err := repo.List()
+	if err != nil {
+		return err
+	}
+	servers := make(map[string]string)
+	for _, form := range forms {
+		parent, login := oyster.SplitLogin(form.Key)
+		if login == "" || !strings.HasPrefix(parent, keyPrefix+"/") {
+			continue
+		}
+		serverURL, err := url.QueryUnescape(strings.TrimPrefix(parent, keyPrefix+"/"))
+		if err != nil {
+			continue
+		}
+		servers[serverURL] = login
+	}
+	return json.NewEncoder(w).Encode(servers)
+}
+
+func run(repo *oyster.FormRepo, action string, r io.Reader, w io.Writer, passphrase func() ([]byte, error)) error {
+	switch action {
+	case "store":
+		return store(repo, r)
+	case "get":
+		return get(repo, r, w, passphrase)
+	case "erase":
+		return erase(repo, r)
+	case "list":
+		return list(repo, w)
+	}
+	return ErrUnknownAction
+}
+
+// getPassword prompts on the controlling terminal as docker connects stdin
+// and stdout to itself.
+func getPassword() ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "Password: ")
+	defer fmt.Fprintf(tty, "\n")
+	return terminal.ReadPassword(int(tty.Fd()))
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: docker-credential-oyster <store|get|erase|list>")
+		os.Exit(1)
+	}
+	config, err := oyster.ReadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+	gpg := oyster.NewGpgRepo(config.GpgHome())
+	fs := oyster.NewCryptoFS(rwvfs.OSPerm(config.Home(), 0600, 0700), gpg)
+	repo := oyster.NewFormRepo(fs)
+	var source oyster.PassphraseSource = oyster.PassphraseFunc(getPassword)
+	if command := config.PassphraseCommand(); command != "" {
+		source = oyster.PassphraseCommand(command)
+	} else if program := config.Pinentry(); program != "" {
+		source = oyster.NewPinentry(program)
+	}
+	source = oyster.OptionalPassphrase(fs, source)
+
+	var out bytes.Buffer
+	if err := run(repo, os.Args[1], os.Stdin, &out, source.Passphrase); err != nil {
+		// Docker reads errors from stdout.
+		fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+	io.Copy(os.Stdout, &out)
+}
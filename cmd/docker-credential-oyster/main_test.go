@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/proglottis/oyster"
+	"github.com/sourcegraph/rwvfs"
+)
+
+func setupRepo(t testing.TB) *oyster.FormRepo {
+	gpg := oyster.NewGpgRepo("../../testdata/gpghome")
+	fs := oyster.NewCryptoFS(rwvfs.Map(map[string]string{}), gpg)
+	if err := oyster.InitRepo(fs, []string{"test@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	return oyster.NewFormRepo(fs)
+}
+
+func testPassphrase() ([]byte, error) {
+	return []byte("password"), nil
+}
+
+func fixture(t testing.TB, name string) *os.File {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func assertFixture(t testing.TB, name string, got *bytes.Buffer) {
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(expected) {
+		t.Errorf("Expected %s to be %q, got %q", name, expected, got.String())
+	}
+}
+
+func runFixture(t testing.TB, repo *oyster.FormRepo, action, in string) (*bytes.Buffer, error) {
+	var out bytes.Buffer
+	r := fixture(t, in)
+	defer r.Close()
+	return &out, run(repo, action, r, &out, testPassphrase)
+}
+
+func TestProtocol(t *testing.T) {
+	repo := setupRepo(t)
+
+	if _, err := runFixture(t, repo, "get", "server.txt"); err != ErrCredentialsNotFound {
+		t.Fatal("Expected ErrCredentialsNotFound, got", err)
+	}
+	if _, err := runFixture(t, repo, "store", "store.json"); err != nil {
+		t.Fatal(err)
+	}
+	// Storing again replaces rather than adds a login.
+	if _, err := runFixture(t, repo, "store", "store.json"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runFixture(t, repo, "get", "server.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFixture(t, "get.json", out)
+
+	var list bytes.Buffer
+	if err := run(repo, "list", strings.NewReader(""), &list, testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	assertFixture(t, "list.json", &list)
+
+	if _, err := runFixture(t, repo, "erase", "server.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runFixture(t, repo, "get", "server.txt"); err != ErrCredentialsNotFound {
+		t.Error("Expected ErrCredentialsNotFound after erase, got", err)
+	}
+	if err := run(repo, "bogus", strings.NewReader(""), &list, testPassphrase); err != ErrUnknownAction {
+		t.Error("Expected ErrUnknownAction, got", err)
+	}
+}
+
+func TestStoreWithoutUsername(t *testing.T) {
+	repo := setupRepo(t)
+	in := strings.NewReader(`{"ServerURL": "https://index.docker.io/v1/", "Username": "", "Secret": "hunter2"}`)
+	if err := run(repo, "store", in, ioutil.Discard, testPassphrase); err != ErrMissingUsername {
+		t.Error("Expected ErrMissingUsername, got", err)
+	}
+	forms, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) > 0 {
+		t.Errorf("Expected nothing stored, got %#v", forms)
+	}
+}
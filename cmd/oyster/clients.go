@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrClientExists  = errors.New("Client already exists")
+	ErrClientMissing = errors.New("No such client")
+)
+
+// Clients maps client names to the bearer tokens they authenticate with.
+type Clients map[string]string
+
+func ReadClients(filename string) (Clients, error) {
+	clients := make(Clients)
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return clients, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		clients[parts[0]] = parts[1]
+	}
+	return clients, scanner.Err()
+}
+
+func (c Clients) Write(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := fmt.Fprintf(f, "%s %s\n", name, c[name]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// Add creates a new random token for name.
+func (c Clients) Add(name string) (string, error) {
+	if _, ok := c[name]; ok {
+		return "", ErrClientExists
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	c[name] = token
+	return token, nil
+}
+
+func (c Clients) Remove(name string) error {
+	if _, ok := c[name]; !ok {
+		return ErrClientMissing
+	}
+	delete(c, name)
+	return nil
+}
+
+// Authenticate returns the name of the client with token.
+func (c Clients) Authenticate(token string) (string, bool) {
+	for name, t := range c {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
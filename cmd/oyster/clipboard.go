@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/codegangsta/cli"
+	"github.com/proglottis/oyster"
+)
+
+var (
+	ErrClipboardUnreadable = errors.New("Clipboard cannot be read")
+	ErrUnknownClipboard    = errors.New("Unknown clipboard, use auto, system, wayland or osc52")
+	ErrUnknownSelection    = errors.New("Unknown selection, use clipboard or primary")
+	ErrNoPrimary           = errors.New("The primary selection is only available on X11 and Wayland")
+)
+
+// Clipboard is somewhere passwords can be copied to.
+type Clipboard interface {
+	ReadAll() (string, error)
+	WriteAll(text string) error
+}
+
+// systemClipboard uses pbcopy, the Windows clipboard or, on X11, xclip or
+// xsel.
+type systemClipboard struct {
+	primary bool
+}
+
+func (c systemClipboard) ReadAll() (string, error) {
+	if err := setPrimary(c.primary); err != nil {
+		return "", err
+	}
+	return clipboard.ReadAll()
+}
+
+func (c systemClipboard) WriteAll(text string) error {
+	if err := setPrimary(c.primary); err != nil {
+		return err
+	}
+	return clipboard.WriteAll(text)
+}
+
+// waylandClipboard uses wl-copy and wl-paste.
+type waylandClipboard struct {
+	primary bool
+}
+
+func (c waylandClipboard) command(name string, args ...string) *exec.Cmd {
+	if c.primary {
+		args = append(args, "--primary")
+	}
+	return exec.Command(name, args...)
+}
+
+func (c waylandClipboard) ReadAll() (string, error) {
+	out, err := c.command("wl-paste", "--no-newline").Output()
+	if _, ok := err.(*exec.ExitError); ok {
+		// wl-paste fails when the clipboard is empty.
+		return "", nil
+	}
+	return string(out), err
+}
+
+func (c waylandClipboard) WriteAll(text string) error {
+	if text == "" {
+		return c.command("wl-copy", "--clear").Run()
+	}
+	cmd := c.command("wl-copy")
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// osc52Clipboard asks the terminal to set its clipboard with an OSC 52
+// escape sequence, which also reaches the local terminal over SSH.
+// Terminals do not allow it to be read back.
+type osc52Clipboard struct {
+	w       io.Writer
+	primary bool
+}
+
+func (c osc52Clipboard) ReadAll() (string, error) {
+	return "", ErrClipboardUnreadable
+}
+
+func (c osc52Clipboard) WriteAll(text string) error {
+	selection := "c"
+	if c.primary {
+		selection = "p"
+	}
+	_, err := fmt.Fprintf(c.w, "\x1b]52;%s;%s\a", selection, base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
+
+// detectClipboard picks a backend for the session, OSC 52 when logged in
+// over SSH without X11 forwarding.
+func detectClipboard() string {
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		return "wayland"
+	case os.Getenv("SSH_TTY") != "" && os.Getenv("DISPLAY") == "":
+		return "osc52"
+	}
+	return "system"
+}
+
+func newClipboard(backend, selection string) (Clipboard, error) {
+	primary := false
+	switch selection {
+	case "", "clipboard":
+	case "primary":
+		primary = true
+	default:
+		return nil, ErrUnknownSelection
+	}
+	if backend == "" || backend == "auto" {
+		backend = detectClipboard()
+	}
+	switch backend {
+	case "system":
+		return systemClipboard{primary: primary}, nil
+	case "wayland":
+		return waylandClipboard{primary: primary}, nil
+	case "osc52":
+		return osc52Clipboard{w: os.Stderr, primary: primary}, nil
+	}
+	return nil, ErrUnknownClipboard
+}
+
+// ClipboardOptions are read from .oysterconfig and overridden by flags.
+type ClipboardOptions struct {
+	Backend    string        `json:"backend"`
+	Selection  string        `json:"selection"`
+	Timeout    time.Duration `json:"timeout"`
+	Restore    bool          `json:"restore,omitempty"`
+	Background bool          `json:"-"`
+}
+
+var clipboardFlags = []cli.Flag{
+	cli.DurationFlag{Name: "timeout", Value: oyster.DefaultClipboardTimeout, Usage: "clear the clipboard after this long, 0 to leave it"},
+	cli.StringFlag{Name: "clipboard", Usage: "clipboard to use: auto, system, wayland or osc52"},
+	cli.StringFlag{Name: "selection", Usage: "X11 or Wayland selection: clipboard or primary"},
+	cli.BoolFlag{Name: "background", Usage: "return immediately and clear the clipboard in the background"},
+	cli.BoolFlag{Name: "restore", Usage: "restore what was on the clipboard before instead of clearing it"},
+}
+
+func clipboardOptions(c *cli.Context, config *oyster.Config) ClipboardOptions {
+	opts := ClipboardOptions{
+		Backend:    config.Clipboard(),
+		Selection:  config.ClipboardSelection(),
+		Timeout:    config.ClipboardTimeout(),
+		Restore:    config.ClipboardRestore() || c.Bool("restore"),
+		Background: c.Bool("background"),
+	}
+	if c.IsSet("timeout") {
+		opts.Timeout = c.Duration("timeout")
+	}
+	if c.IsSet("clipboard") {
+		opts.Backend = c.String("clipboard")
+	}
+	if c.IsSet("selection") {
+		opts.Selection = c.String("selection")
+	}
+	return opts
+}
+
+// clearRequest is passed on stdin to `oyster clipboard-clear`, which clears
+// the clipboard after the command copying to it has returned. Original is
+// only sent when it is to be restored.
+type clearRequest struct {
+	ClipboardOptions
+	Text     string `json:"text"`
+	Original string `json:"original,omitempty"`
+}
+
+// copyToClipboard copies text and then, after the timeout, clears the
+// clipboard unless it has been changed since. With Restore what was there
+// before is put back instead.
+func copyToClipboard(text string, opts ClipboardOptions) error {
+	cb, err := newClipboard(opts.Backend, opts.Selection)
+	if err != nil {
+		return err
+	}
+	original := ""
+	if opts.Restore {
+		original, err = cb.ReadAll()
+		if err != nil && err != ErrClipboardUnreadable {
+			return err
+		}
+	}
+	if err := cb.WriteAll(text); err != nil {
+		return err
+	}
+	if opts.Timeout <= 0 {
+		return nil
+	}
+	if opts.Background {
+		return clearInBackground(clearRequest{ClipboardOptions: opts, Text: text, Original: original})
+	}
+	return clearAfter(cb, text, original, opts.Timeout)
+}
+
+// clearAfter replaces text with original, clearing the clipboard when it
+// is empty, once d has passed or on an interrupt.
+func clearAfter(cb Clipboard, text, original string, d time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, os.Kill)
+	defer signal.Stop(signals)
+	select {
+	case <-signals:
+	case <-time.After(d):
+	}
+	current, err := cb.ReadAll()
+	if err == ErrClipboardUnreadable || err == nil && current == text {
+		return cb.WriteAll(original)
+	}
+	return err
+}
+
+func clearInBackground(req clearRequest) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, "clipboard-clear")
+	cmd.Stderr = os.Stderr
+	// Closing the terminal must not stop it before it clears.
+	detach(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(stdin).Encode(req); err != nil {
+		return err
+	}
+	if err := stdin.Close(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func runClipboardClear(r io.Reader) error {
+	var req clearRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return err
+	}
+	cb, err := newClipboard(req.Backend, req.Selection)
+	if err != nil {
+		return err
+	}
+	return clearAfter(cb, req.Text, req.Original, req.Timeout)
+}
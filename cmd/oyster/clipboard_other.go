@@ -0,0 +1,10 @@
+// +build !freebsd,!linux,!netbsd,!openbsd,!solaris,!dragonfly
+
+package main
+
+func setPrimary(primary bool) error {
+	if primary {
+		return ErrNoPrimary
+	}
+	return nil
+}
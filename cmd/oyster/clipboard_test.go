@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memClipboard struct {
+	text string
+}
+
+func (c *memClipboard) ReadAll() (string, error) {
+	return c.text, nil
+}
+
+func (c *memClipboard) WriteAll(text string) error {
+	c.text = text
+	return nil
+}
+
+func TestOSC52Clipboard(t *testing.T) {
+	var buf bytes.Buffer
+	cb := osc52Clipboard{w: &buf}
+	if err := cb.WriteAll("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\x1b]52;c;aHVudGVyMg==\a"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+	buf.Reset()
+	cb.primary = true
+	cb.WriteAll("")
+	if expected := "\x1b]52;p;\a"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+	if _, err := cb.ReadAll(); err != ErrClipboardUnreadable {
+		t.Error("Expected ErrClipboardUnreadable, got", err)
+	}
+}
+
+func TestNewClipboard(t *testing.T) {
+	cb, err := newClipboard("wayland", "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb != (waylandClipboard{primary: true}) {
+		t.Errorf("Expected primary wayland clipboard, got %#v", cb)
+	}
+	if _, err := newClipboard("x11", ""); err != ErrUnknownClipboard {
+		t.Error("Expected ErrUnknownClipboard, got", err)
+	}
+	if _, err := newClipboard("system", "secondary"); err != ErrUnknownSelection {
+		t.Error("Expected ErrUnknownSelection, got", err)
+	}
+}
+
+func TestClearAfter(t *testing.T) {
+	cb := &memClipboard{text: "hunter2"}
+	if err := clearAfter(cb, "hunter2", "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if cb.text != "" {
+		t.Errorf("Expected clipboard to be cleared, got %#v", cb.text)
+	}
+
+	cb.text = "hunter2"
+	if err := clearAfter(cb, "hunter2", "original", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if cb.text != "original" {
+		t.Errorf("Expected clipboard to be restored, got %#v", cb.text)
+	}
+
+	cb.text = "copied since"
+	if err := clearAfter(cb, "hunter2", "original", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if cb.text != "copied since" {
+		t.Errorf("Expected clipboard changed since to be kept, got %#v", cb.text)
+	}
+
+	var buf bytes.Buffer
+	if err := clearAfter(osc52Clipboard{w: &buf}, "hunter2", "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "52;c;\a") {
+		t.Errorf("Expected unreadable clipboard to be cleared, got %q", buf.String())
+	}
+}
+
+func TestClearRequest(t *testing.T) {
+	buf, err := json.Marshal(clearRequest{Text: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "original") {
+		t.Errorf("Expected no original contents without restore, got %s", buf)
+	}
+}
+
+func TestRunClipboardClear(t *testing.T) {
+	r := strings.NewReader(`{"backend": "x11", "timeout": 1000000, "text": "hunter2"}`)
+	if err := runClipboardClear(r); err != ErrUnknownClipboard {
+		t.Error("Expected ErrUnknownClipboard, got", err)
+	}
+}
@@ -0,0 +1,12 @@
+// +build freebsd linux netbsd openbsd solaris dragonfly
+
+package main
+
+import (
+	"github.com/atotto/clipboard"
+)
+
+func setPrimary(primary bool) error {
+	clipboard.Primary = primary
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	usernameField = "username"
+)
+
+// Credential is the description exchanged with git by a credential helper.
+// See gitcredentials(7).
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// ReadCredential reads attributes until a blank line or the end of input.
+// Unknown attributes are ignored.
+func ReadCredential(r io.Reader) (*Credential, error) {
+	var c Credential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		value := line[i+1:]
+		switch line[:i] {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+	return &c, scanner.Err()
+}
+
+func (c *Credential) Write(w io.Writer) error {
+	for _, attr := range [][2]string{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	} {
+		if attr[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", attr[0], attr[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Credential) URL() string {
+	return c.Protocol + "://" + c.Host + "/" + c.Path
+}
+
+// Key is where the credential is stored. A username is stored as a login of
+// the host so that several accounts can share it.
+func (c *Credential) Key() string {
+	key := strings.Trim(c.Host+"/"+c.Path, "/")
+	if c.Username != "" {
+		return oyster.LoginKey(key, c.Username)
+	}
+	return key
+}
+
+// storedCredential reports whether a form with fields is stored at key.
+func storedCredential(forms *oyster.FormRepo, key string) (bool, error) {
+	form, err := forms.Fields(key)
+	if err == oyster.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(form.Fields) > 0, nil
+}
+
+// findLogin searches for a login of the site of c named after its username.
+func findLogin(forms *oyster.FormRepo, c *Credential) ([]oyster.Form, string, error) {
+	matches, err := forms.Search(c.URL())
+	if err != nil {
+		return nil, "", err
+	}
+	if c.Username != "" {
+		for _, form := range matches {
+			if _, login := oyster.SplitLogin(form.Key); login == c.Username {
+				return matches, form.Key, nil
+			}
+		}
+	}
+	return matches, "", nil
+}
+
+// findCredential picks the form for c: the one stored at its key, then a
+// login named after its username, then the best match for its URL.
+func findCredential(forms *oyster.FormRepo, c *Credential) (string, error) {
+	if ok, err := storedCredential(forms, c.Key()); ok || err != nil {
+		return c.Key(), err
+	}
+	matches, key, err := findLogin(forms, c)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		return key, nil
+	}
+	if len(matches) < 1 {
+		return "", oyster.ErrNotFound
+	}
+	return matches[0].Key, nil
+}
+
+// credentialGet fills in the username and password of c. The passphrase is
+// only asked for once a form is found.
+func credentialGet(forms *oyster.FormRepo, c *Credential, passphrase func() ([]byte, error)) error {
+	key, err := findCredential(forms, c)
+	if err != nil {
+		return err
+	}
+	p, err := passphrase()
+	if err != nil {
+		return err
+	}
+	form, err := forms.Get(key, p)
+	if err != nil {
+		return err
+	}
+	for _, field := range form.Fields {
+		switch field.Name {
+		case usernameField:
+			c.Username = field.Value
+		case oyster.PasswordField:
+			c.Password = field.Value
+		}
+	}
+	return nil
+}
+
+func credentialStore(forms *oyster.FormRepo, c *Credential) error {
+	if c.Host == "" || c.Password == "" {
+		return nil
+	}
+	fields := []oyster.Field{oyster.Field{Name: oyster.PasswordField, Value: c.Password, Sensitive: true}}
+	if c.Username != "" {
+		fields = append(fields, oyster.Field{Name: usernameField, Value: c.Username})
+	}
+	return forms.Put(&oyster.Form{Key: c.Key(), Fields: fields})
+}
+
+// credentialErase removes the form stored at the key of c or the login
+// named after its username. Unlike get it never falls back to another form
+// of the site.
+func credentialErase(forms *oyster.FormRepo, c *Credential) error {
+	if ok, err := storedCredential(forms, c.Key()); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		return forms.Remove(c.Key())
+	}
+	_, key, err := findLogin(forms, c)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return oyster.ErrNotFound
+	}
+	return forms.Remove(key)
+}
+
+// runCredential runs one git credential helper operation. Operations git
+// does not know about are ignored as the protocol requires.
+func runCredential(forms *oyster.FormRepo, op string, r io.Reader, w io.Writer, passphrase func() ([]byte, error)) error {
+	c, err := ReadCredential(r)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "get":
+		err := credentialGet(forms, c, passphrase)
+		if err == oyster.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return c.Write(w)
+	case "store":
+		return credentialStore(forms, c)
+	case "erase":
+		err := credentialErase(forms, c)
+		if err == oyster.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testPassphrase() ([]byte, error) {
+	return []byte("password"), nil
+}
+
+func TestReadCredential(t *testing.T) {
+	c, err := ReadCredential(strings.NewReader("protocol=https\nhost=example.com\npath=repo.git\nwwwauth[]=Basic\n\nignored=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Credential{Protocol: "https", Host: "example.com", Path: "repo.git"}
+	if *c != expected {
+		t.Errorf("Expected %#v, got %#v", expected, *c)
+	}
+	if c.URL() != "https://example.com/repo.git" {
+		t.Errorf("Expected URL, got %#v", c.URL())
+	}
+}
+
+func TestRunCredential(t *testing.T) {
+	s, _ := setupServer(t)
+	forms := s.forms
+
+	var out bytes.Buffer
+	err := runCredential(forms, "store", strings.NewReader("protocol=https\nhost=git.example.com\nusername=bob\npassword=token1\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCredential(forms, "store", strings.NewReader("protocol=https\nhost=git.example.com\nusername=alice\npassword=token2\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runCredential(forms, "get", strings.NewReader("protocol=https\nhost=git.example.com\nusername=alice\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "protocol=https\nhost=git.example.com\nusername=alice\npassword=token2\n"
+	if out.String() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, out.String())
+	}
+
+	out.Reset()
+	err = runCredential(forms, "erase", strings.NewReader("protocol=https\nhost=git.example.com\nusername=bob\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCredential(forms, "get", strings.NewReader("protocol=https\nhost=git.example.com\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "username=alice\n") {
+		t.Errorf("Expected only alice to remain, got %#v", out.String())
+	}
+
+	out.Reset()
+	for _, input := range []string{
+		"protocol=https\nhost=git.example.com\nusername=carol\n",
+		"protocol=https\nhost=git.example.com\n",
+		"protocol=https\nhost=www.example.com\nusername=bob\n",
+	} {
+		if err := runCredential(forms, "erase", strings.NewReader(input), &out, testPassphrase); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := storedCredential(forms, "git.example.com/@alice"); !ok || err != nil {
+		t.Errorf("Expected erase to leave other logins, got %v, %v", ok, err)
+	}
+
+	err = runCredential(forms, "get", strings.NewReader("protocol=https\nhost=missing.com\n"), &out, func() ([]byte, error) {
+		t.Fatal("Expected no passphrase prompt")
+		return nil, nil
+	})
+	if err != nil || out.Len() > 0 {
+		t.Errorf("Expected no output for unknown host, got %#v, %v", out.String(), err)
+	}
+}
+
+func TestRunCredential_port(t *testing.T) {
+	s, _ := setupServer(t)
+	forms := s.forms
+
+	var out bytes.Buffer
+	err := runCredential(forms, "store", strings.NewReader("protocol=https\nhost=git.example.com:8443\npassword=token1\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCredential(forms, "get", strings.NewReader("protocol=https\nhost=git.example.com:8443\n"), &out, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "protocol=https\nhost=git.example.com:8443\npassword=token1\n"
+	if out.String() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, out.String())
+	}
+}
@@ -3,40 +3,23 @@ package main
 import (
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/atotto/clipboard"
 	"github.com/codegangsta/cli"
 	"github.com/proglottis/oyster"
 	"github.com/sourcegraph/rwvfs"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-func copyThenClear(text string, d time.Duration) error {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
-	defer signal.Stop(signals)
-	original, err := clipboard.ReadAll()
-	if err != nil {
-		return err
-	}
-	err = clipboard.WriteAll(text)
-	if err != nil {
-		return err
-	}
-	select {
-	case <-signals:
-	case <-time.After(d):
-	}
-	current, _ := clipboard.ReadAll()
-	if current == text {
-		return clipboard.WriteAll(original)
-	}
-	return nil
-}
-
 func bashCompleteKeys(repo *oyster.FileRepo) func(*cli.Context) {
 	return func(c *cli.Context) {
 		if len(c.Args()) > 0 {
@@ -48,6 +31,35 @@ func bashCompleteKeys(repo *oyster.FileRepo) func(*cli.Context) {
 	}
 }
 
+func printMeta(meta *oyster.Meta) {
+	fmt.Printf("tags: %s\n", strings.Join(meta.Tags, ", "))
+	for _, url := range meta.URLs {
+		fmt.Printf("url: %s\n", url)
+	}
+	if meta.Notes != "" {
+		fmt.Printf("notes: %s\n", meta.Notes)
+	}
+	fmt.Printf("created: %s\n", meta.Created.Local().Format(time.RFC1123))
+	fmt.Printf("modified: %s\n", meta.Modified.Local().Format(time.RFC1123))
+}
+
+func removeStrings(list []string, remove []string) []string {
+	kept := list[:0]
+	for _, s := range list {
+		found := false
+		for _, r := range remove {
+			if s == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
 type password struct {
 	Password []byte
 	Err      error
@@ -90,30 +102,107 @@ func getPassword() ([]byte, error) {
 	}()
 	select {
 	case <-signals:
-		return nil, fmt.Errorf("Password entry cancelled")
+		return nil, ErrCancelled
 	case password := <-passwords:
 		return password.Password, password.Err
 	}
 }
 
+// passphraseSource chooses where the passphrase comes from: --passphrase-fd,
+// --passphrase-file, the configured passphrase command, else a prompt with
+// the configured pinentry or on the terminal.
+func passphraseSource(c *cli.Context, fs *oyster.CryptoFS, config *oyster.Config) oyster.PassphraseSource {
+	var source oyster.PassphraseSource = oyster.PassphraseFunc(getPassword)
+	switch {
+	case c.GlobalInt("passphrase-fd") >= 0:
+		source = oyster.PassphraseReader(os.NewFile(uintptr(c.GlobalInt("passphrase-fd")), "passphrase-fd"))
+	case c.GlobalString("passphrase-file") != "":
+		source = oyster.PassphraseFile(c.GlobalString("passphrase-file"))
+	case config.PassphraseCommand() != "":
+		source = oyster.PassphraseCommand(config.PassphraseCommand())
+	case config.Pinentry() != "":
+		source = oyster.NewPinentry(config.Pinentry())
+	}
+	return oyster.OptionalPassphrase(fs, source)
+}
+
+func confirmUse(key string) bool {
+	fmt.Fprintf(os.Stderr, "Allow use of %s? [y/N] ", key)
+	answer, err := readline(os.Stdin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(answer), "y")
+}
+
+func readline(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+func walkKeys(repo *oyster.FileRepo) ([]string, error) {
+	keys := make([]string, 0)
+	err := repo.Walk(func(key string) {
+		keys = append(keys, key)
+	})
+	return keys, err
+}
+
 func main() {
 	config, err := oyster.ReadConfig()
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
 	}
 	gpg := oyster.NewGpgRepo(config.GpgHome())
 	fs := oyster.NewCryptoFS(rwvfs.OSPerm(config.Home(), 0600, 0700), gpg)
-	repo := oyster.NewFileRepo(fs)
+	store := oyster.NewStore(fs)
+	repo := store.Files
+	forms := store.Forms
+	getPassphrase := func(c *cli.Context) ([]byte, error) {
+		return passphraseSource(c, fs, config).Passphrase()
+	}
 	app := cli.NewApp()
 	app.Name = "oyster"
 	app.Usage = "GPG password storage"
 	app.Version = "0.2.10"
 	app.EnableBashCompletion = true
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "format", Value: "text", Usage: "output format, text or json"},
+		cli.BoolFlag{Name: "null, 0", Usage: "separate listed keys with NUL instead of newline"},
+		cli.IntFlag{Name: "passphrase-fd", Value: -1, Usage: "read the passphrase from this file descriptor"},
+		cli.StringFlag{Name: "passphrase-file", Usage: "read the passphrase from this file"},
+	}
+	app.Before = func(c *cli.Context) error {
+		if !validFormat(c.GlobalString("format")) {
+			fail(c, ErrUsage)
+		}
+		return nil
+	}
 	app.Action = func(c *cli.Context) {
-		repo.Walk(func(file string) {
-			fmt.Println(file)
-		})
+		// Anything left is a command that does not exist.
+		if c.Args().Present() {
+			fail(c, ErrUsage)
+		}
+		keys, err := walkKeys(repo)
+		if err != nil {
+			fail(c, err)
+		}
+		if err := newOutput(c).Keys(keys); err != nil {
+			fail(c, err)
+		}
 	}
 
 	cli.CommandHelpTemplate = `NAME:
@@ -142,77 +231,519 @@ EXAMPLE:
 			Action: func(c *cli.Context) {
 				args := c.Args()
 				if !args.Present() {
-					fmt.Println("Must provide at least one GPG ID")
-					return
+					fail(c, ErrUsage)
 				}
 				if err := oyster.InitRepo(fs, args); err != nil {
-					fmt.Println(err)
+					fail(c, err)
 				}
 			},
 		},
 		{
 			Name:  "get",
-			Usage: "Print a password to console",
+			Usage: "Print a password, or a single field with key:field, to console",
 			Action: func(c *cli.Context) {
-				passphrase, err := getPassword()
+				passphrase, err := getPassphrase(c)
 				if err != nil {
-					panic(err)
+					fail(c, err)
+				}
+				out := newOutput(c)
+				key, field := store.SplitField(c.Args().First())
+				if field != "" {
+					value, err := store.Field(key, field, passphrase)
+					if err != nil {
+						fail(c, err)
+					}
+					if err := out.Value(key, field, value); err != nil {
+						fail(c, err)
+					}
+					return
+				}
+				if out.json() || !store.IsFile(key) {
+					form, err := store.Get(key, passphrase)
+					if err != nil {
+						fail(c, err)
+					}
+					if err := out.Form(form); err != nil {
+						fail(c, err)
+					}
+					return
 				}
-				plaintext, err := repo.Open(c.Args().First(), passphrase)
+				plaintext, err := repo.Open(key, passphrase)
 				if err != nil {
-					panic(err)
+					fail(c, err)
 				}
 				defer plaintext.Close()
-				io.Copy(os.Stdout, plaintext)
+				if _, err := io.Copy(os.Stdout, plaintext); err != nil {
+					fail(c, err)
+				}
 			},
 			BashComplete: bashCompleteKeys(repo),
 		},
 		{
 			Name:  "copy",
-			Usage: "Copy a password to the clipboard",
+			Usage: "Copy a password, or a single field with key:field, to the clipboard",
 			Action: func(c *cli.Context) {
-				passphrase, err := getPassword()
+				passphrase, err := getPassphrase(c)
 				if err != nil {
-					panic(err)
+					fail(c, err)
 				}
-				password, err := repo.Line(c.Args().First(), passphrase)
-				if err != nil {
-					panic(err)
+				key, field := store.SplitField(c.Args().First())
+				if field == "" {
+					field = oyster.PasswordField
 				}
-				err = copyThenClear(password, 45*time.Second)
+				password, err := store.Field(key, field, passphrase)
 				if err != nil {
-					panic(err)
+					fail(c, err)
+				}
+				if err := copyToClipboard(password, clipboardOptions(c, config)); err != nil {
+					fail(c, err)
 				}
 			},
+			Flags:        clipboardFlags,
 			BashComplete: bashCompleteKeys(repo),
 		},
 		{
 			Name:  "put",
 			Usage: "Store a password",
 			Action: func(c *cli.Context) {
+				if !c.Args().Present() {
+					fail(c, ErrUsage)
+				}
 				plaintext, err := repo.Create(c.Args().First())
 				if err != nil {
-					panic(err)
+					fail(c, err)
 				}
-				defer plaintext.Close()
 				if terminal.IsTerminal(0) {
-					fmt.Println("Enter your password...")
+					fmt.Fprintln(os.Stderr, "Enter your password...")
 				}
 				interruptibleCopy(plaintext, os.Stdin)
+				if err := plaintext.Close(); err != nil {
+					fail(c, err)
+				}
 			},
 			BashComplete: bashCompleteKeys(repo),
 		},
+		{
+			Name:  "ls",
+			Usage: "List passwords and forms as a tree",
+			Description: `List the keys under a prefix, which may be a glob. On a terminal keys are drawn as a tree, otherwise
+   they are printed one per line.
+
+EXAMPLE:
+   oyster ls --depth 1
+   oyster ls --forms 'work/*'
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "tag", Usage: "only list passwords and forms with this tag"},
+				cli.IntFlag{Name: "depth", Usage: "only list this many levels of the tree"},
+				cli.BoolFlag{Name: "forms", Usage: "only list forms"},
+				cli.BoolFlag{Name: "files", Usage: "only list password files"},
+			},
+			Action: func(c *cli.Context) {
+				withFiles, withForms := c.Bool("files"), c.Bool("forms")
+				if !withFiles && !withForms {
+					withFiles, withForms = true, true
+				}
+				entries, err := store.List(withFiles, withForms)
+				if err != nil {
+					fail(c, err)
+				}
+				prefix := c.Args().First()
+				entries = filterEntries(entries, prefix)
+				if tag := c.String("tag"); tag != "" {
+					passphrase, err := getPassphrase(c)
+					if err != nil {
+						fail(c, err)
+					}
+					if entries, err = filterTagged(store, entries, tag, passphrase); err != nil {
+						fail(c, err)
+					}
+				}
+				out := newOutput(c)
+				if out.json() || out.null || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+					if err := out.Keys(limitDepth(entries.Keys(), c.Int("depth"))); err != nil {
+						fail(c, err)
+					}
+					return
+				}
+				title := prefix
+				if title == "" {
+					title = "Oyster"
+				}
+				if err := Tree(os.Stdout, title, entries, c.Int("depth"), true); err != nil {
+					fail(c, err)
+				}
+			},
+		},
+		{
+			Name:  "meta",
+			Usage: "Show or change the tags, URLs and notes of a password",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "tag", Value: &cli.StringSlice{}, Usage: "add a tag"},
+				cli.StringSliceFlag{Name: "untag", Value: &cli.StringSlice{}, Usage: "remove a tag"},
+				cli.StringSliceFlag{Name: "url", Value: &cli.StringSlice{}, Usage: "add a URL"},
+				cli.StringFlag{Name: "notes", Usage: "replace the notes"},
+			},
+			Action: func(c *cli.Context) {
+				key := c.Args().First()
+				passphrase, err := getPassphrase(c)
+				if err != nil {
+					fail(c, err)
+				}
+				meta, err := repo.Meta(key, passphrase)
+				switch err {
+				case oyster.ErrNotFound:
+					meta = &oyster.Meta{}
+				case nil:
+				default:
+					fail(c, err)
+				}
+				if c.IsSet("tag") || c.IsSet("untag") || c.IsSet("url") || c.IsSet("notes") {
+					meta.Tags = append(removeStrings(meta.Tags, c.StringSlice("tag")), c.StringSlice("tag")...)
+					meta.Tags = removeStrings(meta.Tags, c.StringSlice("untag"))
+					meta.URLs = append(removeStrings(meta.URLs, c.StringSlice("url")), c.StringSlice("url")...)
+					if c.IsSet("notes") {
+						meta.Notes = c.String("notes")
+					}
+					if err := repo.SetMeta(key, meta); err != nil {
+						fail(c, err)
+					}
+				}
+				if err := newOutput(c).Meta(key, meta); err != nil {
+					fail(c, err)
+				}
+			},
+			BashComplete: bashCompleteKeys(repo),
+		},
+		{
+			Name:  "find",
+			Usage: "Search for passwords and forms",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "json", Usage: "deprecated, use --format=json before the command", Hidden: true},
+			},
+			Action: func(c *cli.Context) {
+				matches, err := repo.Find(c.Args().First())
+				if err != nil {
+					fail(c, err)
+				}
+				formMatches, err := forms.Find(c.Args().First())
+				if err != nil {
+					fail(c, err)
+				}
+				matches = append(matches, formMatches...)
+				sort.Sort(oyster.MatchSlice(matches))
+				out := newOutput(c)
+				if c.Bool("json") {
+					out.format = "json"
+				}
+				if err := out.Matches(matches); err != nil {
+					fail(c, err)
+				}
+			},
+		},
+		{
+			Name:  "form",
+			Usage: "Show or copy forms saved by the browser extension",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "Print the fields of a form",
+					Action: func(c *cli.Context) {
+						if !c.Args().Present() {
+							fail(c, ErrUsage)
+						}
+						passphrase, err := getPassphrase(c)
+						if err != nil {
+							fail(c, err)
+						}
+						key := c.Args().First()
+						if !store.IsForm(key) {
+							fail(c, oyster.ErrNotFound)
+						}
+						form, err := forms.Get(key, passphrase)
+						if err != nil {
+							fail(c, err)
+						}
+						if err := newOutput(c).Form(form); err != nil {
+							fail(c, err)
+						}
+					},
+				},
+				{
+					Name:  "copy",
+					Usage: "Copy a field of a form, the password by default, to the clipboard",
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						if !args.Present() {
+							fail(c, ErrUsage)
+						}
+						field := args.Get(1)
+						if field == "" {
+							field = oyster.PasswordField
+						}
+						passphrase, err := getPassphrase(c)
+						if err != nil {
+							fail(c, err)
+						}
+						if !store.IsForm(args.First()) {
+							fail(c, oyster.ErrNotFound)
+						}
+						value, err := forms.Field(args.First(), field, passphrase)
+						if err != nil {
+							fail(c, err)
+						}
+						if err := copyToClipboard(value, clipboardOptions(c, config)); err != nil {
+							fail(c, err)
+						}
+					},
+					Flags: clipboardFlags,
+				},
+			},
+		},
+		{
+			Name:  "attach",
+			Usage: "Store a file, such as an SSH key or certificate",
+			Description: `Encrypt a file into the password store. The file is streamed so it may be of any size.
+
+EXAMPLE:
+   oyster attach ssh/id_ed25519 ~/.ssh/id_ed25519
+`,
+			Action: func(c *cli.Context) {
+				args := c.Args()
+				if len(args) != 2 {
+					fail(c, ErrUsage)
+				}
+				f, err := os.Open(args.Get(1))
+				if err != nil {
+					fail(c, err)
+				}
+				defer f.Close()
+				n, err := repo.Attach(args.First(), f)
+				if err != nil {
+					fail(c, err)
+				}
+				fmt.Fprintf(os.Stderr, "Attached %d bytes to %s\n", n, args.First())
+			},
+			BashComplete: bashCompleteKeys(repo),
+		},
+		{
+			Name:  "extract",
+			Usage: "Write a stored file to disk or the console",
+			Description: `Decrypt a stored file. Without a destination it is written to the console, an existing destination is never overwritten.
+
+EXAMPLE:
+   oyster extract ssh/id_ed25519 ~/.ssh/id_ed25519
+`,
+			Action: func(c *cli.Context) {
+				args := c.Args()
+				passphrase, err := getPassphrase(c)
+				if err != nil {
+					fail(c, err)
+				}
+				if len(args) < 2 {
+					if _, err := repo.Extract(args.First(), os.Stdout, passphrase); err != nil {
+						fail(c, err)
+					}
+					return
+				}
+				f, err := os.OpenFile(args.Get(1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					fail(c, err)
+				}
+				n, err := repo.Extract(args.First(), f, passphrase)
+				if err != nil {
+					f.Close()
+					os.Remove(args.Get(1))
+					fail(c, err)
+				}
+				if err := f.Close(); err != nil {
+					fail(c, err)
+				}
+				fmt.Fprintf(os.Stderr, "Extracted %d bytes to %s\n", n, args.Get(1))
+			},
+			BashComplete: bashCompleteKeys(repo),
+		},
+		{
+			Name:  "serve",
+			Usage: "Serve passwords and forms to local tools over HTTP",
+			Description: `Serve a JSON API on a loopback address or a Unix socket. Clients authenticate with a bearer token
+   created by --add-client and unlock with POST /unlock, passing the returned session in the X-Oyster-Session header.
+
+EXAMPLE:
+   oyster serve --add-client editor
+   oyster serve --addr 127.0.0.1:7878 --origin http://localhost:3000
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "addr", Value: "127.0.0.1:7878", Usage: "loopback address to listen on"},
+				cli.StringFlag{Name: "socket", Usage: "Unix socket to listen on instead of addr"},
+				cli.StringSliceFlag{Name: "origin", Value: &cli.StringSlice{}, Usage: "allow browser requests from this origin"},
+				cli.StringFlag{Name: "add-client", Usage: "create a token for a client and print it"},
+				cli.StringFlag{Name: "remove-client", Usage: "revoke the token of a client"},
+			},
+			Action: func(c *cli.Context) {
+				clients, err := ReadClients(config.ClientsFile())
+				if err != nil {
+					fail(c, err)
+				}
+				if name := c.String("add-client"); name != "" {
+					token, err := clients.Add(name)
+					if err != nil {
+						fail(c, err)
+					}
+					if err := clients.Write(config.ClientsFile()); err != nil {
+						fail(c, err)
+					}
+					fmt.Println(token)
+					return
+				}
+				if name := c.String("remove-client"); name != "" {
+					if err := clients.Remove(name); err != nil {
+						fail(c, err)
+					}
+					if err := clients.Write(config.ClientsFile()); err != nil {
+						fail(c, err)
+					}
+					return
+				}
+				if len(clients) < 1 {
+					fail(c, ErrNoClients)
+				}
+				sessions := oyster.NewSessions(config.SessionTTL())
+				defer sessions.LockAll()
+				server := NewServer(fs, forms, sessions, clients)
+				server.origins = c.StringSlice("origin")
+				var listener net.Listener
+				if socket := c.String("socket"); socket != "" {
+					server.hosts = nil
+					listener, err = listenUnix(socket)
+				} else {
+					listener, err = listenLoopback(c.String("addr"))
+				}
+				if err != nil {
+					fail(c, err)
+				}
+				defer listener.Close()
+				closed := make(chan struct{})
+				go func() {
+					signals := make(chan os.Signal, 1)
+					signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+					<-signals
+					close(closed)
+					listener.Close()
+				}()
+				fmt.Fprintf(os.Stderr, "Listening on %s\n", listener.Addr())
+				if err := http.Serve(listener, server); err != nil {
+					select {
+					case <-closed:
+					default:
+						sessions.LockAll()
+						fail(c, err)
+					}
+				}
+			},
+		},
+		{
+			Name:  "git-credential",
+			Usage: "Act as a git credential helper",
+			Description: `Implements the get, store and erase operations of git's credential helper protocol. Credentials are
+   saved as forms with username and password fields, matched by URL like the browser extension.
+
+EXAMPLE:
+   git config --global credential.helper '!oyster git-credential'
+`,
+			Action: func(c *cli.Context) {
+				err := runCredential(forms, c.Args().First(), os.Stdin, os.Stdout, passphraseSource(c, fs, config).Passphrase)
+				if err != nil {
+					fail(c, err)
+				}
+			},
+		},
+		{
+			Name:  "ssh-agent",
+			Usage: "Serve SSH keys stored under ssh/ to SSH clients",
+			Description: `Implements the ssh-agent protocol on a Unix socket. Private keys are stored in PEM form with
+   "oyster put ssh/<name>" and decrypted each time they are used.
+
+EXAMPLE:
+   oyster ssh-agent --confirm --lifetime 1h
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "socket", Usage: "Unix socket to listen on"},
+				cli.BoolFlag{Name: "confirm", Usage: "ask before each use of a key"},
+				cli.StringFlag{Name: "lifetime", Usage: "how long to keep the passphrase, e.g. 30m"},
+			},
+			Action: func(c *cli.Context) {
+				a := newSSHAgent(fs, passphraseSource(c, fs, config).Passphrase)
+				if c.Bool("confirm") {
+					a.confirm = confirmUse
+				}
+				if lifetime := c.String("lifetime"); lifetime != "" {
+					var err error
+					a.lifetime, err = time.ParseDuration(lifetime)
+					if err != nil {
+						fail(c, err)
+					}
+				}
+				socket := c.String("socket")
+				if socket == "" {
+					socket = filepath.Join(os.TempDir(), fmt.Sprintf("oyster-agent.%d", os.Getpid()))
+				}
+				listener, err := listenUnix(socket)
+				if err != nil {
+					fail(c, err)
+				}
+				defer os.Remove(socket)
+				defer listener.Close()
+				go func() {
+					signals := make(chan os.Signal, 1)
+					signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+					<-signals
+					listener.Close()
+				}()
+				fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						break
+					}
+					go func() {
+						defer conn.Close()
+						agent.ServeAgent(a, conn)
+					}()
+				}
+				a.RemoveAll()
+			},
+		},
 		{
 			Name:      "remove",
 			ShortName: "rm",
 			Usage:     "Remove a password",
 			Action: func(c *cli.Context) {
 				if err := repo.Remove(c.Args().First()); err != nil {
-					panic(err)
+					fail(c, err)
 				}
 			},
 			BashComplete: bashCompleteKeys(repo),
 		},
+		{
+			Name:   "clipboard-clear",
+			Usage:  "Clear the clipboard for `copy --background`",
+			Hidden: true,
+			Action: func(c *cli.Context) {
+				if err := runClipboardClear(os.Stdin); err != nil {
+					fail(c, err)
+				}
+			},
+		},
+	}
+	args := os.Args
+	// Installed as git-credential-oyster, `credential.helper oyster` works.
+	if filepath.Base(args[0]) == "git-credential-oyster" {
+		args = append([]string{"oyster", "git-credential"}, args[1:]...)
+	}
+	// Actions exit themselves, so an error is a flag or argument that
+	// could not be parsed.
+	if err := app.Run(args); err != nil {
+		os.Exit(exitUsage)
 	}
-	app.Run(os.Args)
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/proglottis/oyster"
+)
+
+// Exit codes are stable so that scripts can tell failures apart.
+const (
+	exitError         = 1
+	exitUsage         = 2
+	exitNotFound      = 3
+	exitBadPassphrase = 4
+	exitNoKeys        = 5
+	exitCancelled     = 6
+)
+
+var (
+	ErrCancelled = errors.New("Password entry cancelled")
+	ErrUsage     = errors.New("Invalid arguments")
+)
+
+// ErrorOutput is the schema of errors printed with --format=json.
+type ErrorOutput struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Exit    int    `json:"exit"`
+}
+
+type KeysOutput struct {
+	Keys []string `json:"keys"`
+}
+
+type ValueOutput struct {
+	Key   string `json:"key"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type MetaOutput struct {
+	Key  string       `json:"key"`
+	Meta *oyster.Meta `json:"meta"`
+}
+
+func errorCode(err error) (string, int) {
+	switch err {
+	case oyster.ErrNotFound:
+		return "NOT_FOUND", exitNotFound
+	case oyster.ErrCannotDecryptKey:
+		return "BAD_PASSPHRASE", exitBadPassphrase
+	case oyster.ErrNoMatchingKeys:
+		return "NO_MATCHING_KEYS", exitNoKeys
+	case ErrCancelled, oyster.ErrPinentryCancelled:
+		return "CANCELLED", exitCancelled
+	case ErrUsage:
+		return "USAGE", exitUsage
+	}
+	return "ERROR", exitError
+}
+
+// Output prints command results as text, NUL separated text or JSON.
+type Output struct {
+	w      io.Writer
+	errw   io.Writer
+	format string
+	null   bool
+}
+
+func newOutput(c *cli.Context) *Output {
+	return &Output{
+		w:      os.Stdout,
+		errw:   os.Stderr,
+		format: c.GlobalString("format"),
+		null:   c.GlobalBool("null"),
+	}
+}
+
+// validFormat reports whether format is one Output can print.
+func validFormat(format string) bool {
+	return format == "text" || format == "json"
+}
+
+func (o *Output) json() bool {
+	return o.format == "json"
+}
+
+func (o *Output) encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (o *Output) Keys(keys []string) error {
+	if o.json() {
+		if keys == nil {
+			keys = []string{}
+		}
+		return o.encode(o.w, KeysOutput{Keys: keys})
+	}
+	sep := "\n"
+	if o.null {
+		sep = "\x00"
+	}
+	for _, key := range keys {
+		if _, err := io.WriteString(o.w, key+sep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches prints ranked search results, best first. Text output only has
+// their keys.
+func (o *Output) Matches(matches []oyster.Match) error {
+	if o.json() {
+		if matches == nil {
+			matches = []oyster.Match{}
+		}
+		return o.encode(o.w, matches)
+	}
+	keys := make([]string, 0, len(matches))
+	for _, match := range matches {
+		keys = append(keys, match.Key)
+	}
+	return o.Keys(keys)
+}
+
+func (o *Output) Value(key, field, value string) error {
+	if o.json() {
+		return o.encode(o.w, ValueOutput{Key: key, Field: field, Value: value})
+	}
+	_, err := fmt.Fprintln(o.w, value)
+	return err
+}
+
+func (o *Output) Form(form *oyster.Form) error {
+	if o.json() {
+		return o.encode(o.w, form)
+	}
+	return oyster.WriteEntry(o.w, form.Fields)
+}
+
+func (o *Output) Meta(key string, meta *oyster.Meta) error {
+	if o.json() {
+		return o.encode(o.w, MetaOutput{Key: key, Meta: meta})
+	}
+	printMeta(meta)
+	return nil
+}
+
+// Error prints err and returns the exit code for it.
+func (o *Output) Error(err error) int {
+	code, exit := errorCode(err)
+	if o.json() {
+		o.encode(o.errw, ErrorOutput{Error: ErrorDetail{Code: code, Message: err.Error(), Exit: exit}})
+	} else {
+		fmt.Fprintln(o.errw, err)
+	}
+	return exit
+}
+
+// fail reports err and exits with its exit code.
+func fail(c *cli.Context, err error) {
+	os.Exit(newOutput(c).Error(err))
+}
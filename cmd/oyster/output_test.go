@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/proglottis/oyster"
+)
+
+func TestOutputKeys(t *testing.T) {
+	var buf bytes.Buffer
+	out := &Output{w: &buf, null: true}
+	if err := out.Keys([]string{"a b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a b\x00c\x00" {
+		t.Errorf("Expected NUL separated keys, got %q", buf.String())
+	}
+
+	buf.Reset()
+	out = &Output{w: &buf, format: "json"}
+	if err := out.Keys(nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\"keys\":[]}\n" {
+		t.Errorf("Expected empty key list, got %q", buf.String())
+	}
+}
+
+func TestOutputMatches(t *testing.T) {
+	var buf bytes.Buffer
+	out := &Output{w: &buf}
+	matches := []oyster.Match{oyster.Match{Key: "example.com"}, oyster.Match{Key: "email"}}
+	if err := out.Matches(matches); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "example.com\nemail\n" {
+		t.Errorf("Expected keys in rank order, got %q", buf.String())
+	}
+
+	buf.Reset()
+	out.format = "json"
+	if err := out.Matches(nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("Expected empty match list, got %q", buf.String())
+	}
+
+	for format, valid := range map[string]bool{"text": true, "json": true, "yaml": false, "": false} {
+		if validFormat(format) != valid {
+			t.Errorf("Expected format %#v valid to be %v", format, valid)
+		}
+	}
+}
+
+func TestOutputError(t *testing.T) {
+	var buf bytes.Buffer
+	out := &Output{errw: &buf, format: "json"}
+	if exit := out.Error(oyster.ErrCannotDecryptKey); exit != exitBadPassphrase {
+		t.Errorf("Expected exit %d, got %d", exitBadPassphrase, exit)
+	}
+	var e ErrorOutput
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	expected := ErrorDetail{Code: "BAD_PASSPHRASE", Message: "Cannot decrypt key", Exit: exitBadPassphrase}
+	if e.Error != expected {
+		t.Errorf("Expected %#v, got %#v", expected, e.Error)
+	}
+
+	for err, exit := range map[error]int{
+		oyster.ErrNotFound:       exitNotFound,
+		os.ErrNotExist:           exitError,
+		ErrCancelled:             exitCancelled,
+		ErrUsage:                 exitUsage,
+		ErrAgentNoKey:            exitError,
+		oyster.ErrNoMatchingKeys: exitNoKeys,
+	} {
+		if _, code := errorCode(err); code != exit {
+			t.Errorf("Expected exit %d for %v, got %d", exit, err, code)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	sessionHeader = "X-Oyster-Session"
+)
+
+var (
+	ErrUnauthorized = errors.New("Missing or unknown client token")
+	ErrBadHost      = errors.New("Host not allowed")
+	ErrBadOrigin    = errors.New("Origin not allowed")
+	ErrBadMethod    = errors.New("Method not allowed")
+	ErrBadKey       = errors.New("Invalid key")
+	ErrNoClients    = errors.New("No clients. Add one with `oyster serve --add-client <name>`")
+	ErrNotSocket    = errors.New("Socket path exists and is not a socket")
+	ErrSocketInUse  = errors.New("Socket is in use by another process")
+)
+
+// loopbackHosts are the only Host headers accepted over TCP so that a web
+// page cannot reach the server by rebinding its own domain to loopback.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+type UnlockRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type SessionResponse struct {
+	Token string `json:"token"`
+	TTL   int    `json:"ttl"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes the file and form repositories over HTTP. Every request
+// needs a client bearer token and requests that decrypt need a session from
+// /unlock, passed in the X-Oyster-Session header.
+type Server struct {
+	fs       *oyster.CryptoFS
+	files    *oyster.FileRepo
+	forms    *oyster.FormRepo
+	store    *oyster.Store
+	sessions *oyster.Sessions
+	clients  Clients
+	// hosts are the allowed Host names, any when nil. origins are the
+	// allowed CORS origins.
+	hosts   []string
+	origins []string
+	mu      sync.RWMutex
+}
+
+func NewServer(fs *oyster.CryptoFS, forms *oyster.FormRepo, sessions *oyster.Sessions, clients Clients) *Server {
+	files := oyster.NewFileRepo(fs)
+	return &Server{
+		fs:       fs,
+		files:    files,
+		forms:    forms,
+		store:    &oyster.Store{Files: files, Forms: forms},
+		sessions: sessions,
+		clients:  clients,
+		hosts:    loopbackHosts,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.allowedHost(r.Host) {
+		writeError(w, http.StatusForbidden, ErrBadHost)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !contains(s.origins, origin) {
+			writeError(w, http.StatusForbidden, ErrBadOrigin)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+sessionHeader)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if _, ok := s.clients.Authenticate(token); !ok || token == "" || token == auth {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "PUT", "DELETE":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	default:
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "unlock":
+		s.unlock(w, r)
+	case path == "lock":
+		s.lock(w, r)
+	case path == "forms" || path == "forms/search":
+		s.listForms(w, r)
+	case strings.HasPrefix(path, "forms/"):
+		key := strings.TrimPrefix(path, "forms/")
+		if !validKey(key) {
+			writeError(w, http.StatusBadRequest, ErrBadKey)
+			return
+		}
+		s.form(w, r, key)
+	case path == "files" || path == "files/search":
+		s.listFiles(w, r)
+	case strings.HasPrefix(path, "files/"):
+		key := strings.TrimPrefix(path, "files/")
+		if !validKey(key) {
+			writeError(w, http.StatusBadRequest, ErrBadKey)
+			return
+		}
+		s.file(w, r, key)
+	default:
+		writeError(w, http.StatusNotFound, oyster.ErrNotFound)
+	}
+}
+
+func (s *Server) allowedHost(host string) bool {
+	if s.hosts == nil {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return contains(s.hosts, strings.Trim(host, "[]"))
+}
+
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+		return
+	}
+	var req UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	token, err := s.sessions.Unlock(s.fs, []byte(req.Passphrase))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, SessionResponse{Token: token, TTL: int(s.sessions.TTL() / time.Second)})
+}
+
+func (s *Server) lock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+		return
+	}
+	if err := s.sessions.Lock(r.Header.Get(sessionHeader)); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listForms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+		return
+	}
+	var forms []oyster.Form
+	var err error
+	if q := r.URL.Query().Get("q"); q != "" {
+		forms, err = s.forms.Search(q)
+	} else {
+		forms, err = s.forms.List()
+	}
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, s.store.FilterForms(forms))
+}
+
+func (s *Server) form(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != "PUT" && !s.store.IsForm(key) {
+		writeError(w, http.StatusNotFound, oyster.ErrNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		var form *oyster.Form
+		err := s.sessions.Use(r.Header.Get(sessionHeader), func(fs *oyster.CryptoFS) error {
+			var err error
+			form, err = s.forms.Unlocked(fs).Get(key, nil)
+			return err
+		})
+		if err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		writeJSON(w, form)
+	case "PUT":
+		var form oyster.Form
+		if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		form.Key = key
+		if err := s.forms.Put(&form); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if err := s.forms.Remove(key); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+	}
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+		return
+	}
+	keys := make([]string, 0)
+	if q := r.URL.Query().Get("q"); q != "" {
+		matches, err := s.files.Find(q)
+		if err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		// Best matches first, like forms.
+		for _, match := range matches {
+			if !contains(keys, match.Key) {
+				keys = append(keys, match.Key)
+			}
+		}
+		writeJSON(w, keys)
+		return
+	}
+	if err := s.files.Walk(func(key string) { keys = append(keys, key) }); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, keys)
+}
+
+func (s *Server) file(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case "GET":
+		var form *oyster.Form
+		err := s.sessions.Use(r.Header.Get(sessionHeader), func(fs *oyster.CryptoFS) error {
+			var err error
+			form, err = s.files.Unlocked(fs).Form(key, nil)
+			return err
+		})
+		if err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		writeJSON(w, form)
+	case "PUT":
+		var form oyster.Form
+		if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		form.Key = key
+		if err := s.files.PutForm(&form); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if err := s.files.Remove(key); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrBadMethod)
+	}
+}
+
+// listenLoopback refuses addresses that are reachable from other machines.
+func listenLoopback(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, ErrBadHost
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on a socket only the current user can connect to. A
+// socket left behind by an earlier run is replaced, but nothing else is.
+func listenUnix(socket string) (net.Listener, error) {
+	if fi, err := os.Lstat(socket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, ErrNotSocket
+		}
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, ErrSocketInUse
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
+
+func writeRepoError(w http.ResponseWriter, err error) {
+	switch err {
+	case oyster.ErrNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case oyster.ErrInvalidSession:
+		writeError(w, http.StatusUnauthorized, err)
+	case oyster.ErrCannotDecryptKey:
+		writeError(w, http.StatusForbidden, err)
+	case oyster.ErrInvalidField, oyster.ErrInvalidAttachment:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// validKey reports whether key stays within the repository and does not
+// name one of its hidden files.
+func validKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
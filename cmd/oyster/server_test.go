@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/proglottis/oyster"
+	"github.com/sourcegraph/rwvfs"
+)
+
+func setupServer(t testing.TB) (*Server, string) {
+	gpg := oyster.NewGpgRepo("../../testdata/gpghome")
+	fs := oyster.NewCryptoFS(rwvfs.Map(map[string]string{}), gpg)
+	if err := oyster.InitRepo(fs, []string{"test@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	forms := oyster.NewFormRepo(fs)
+	form := &oyster.Form{
+		Key:    "example.com",
+		Fields: []oyster.Field{oyster.Field{Name: "password", Value: "password123"}},
+	}
+	if err := forms.Put(form); err != nil {
+		t.Fatal(err)
+	}
+	clients := make(Clients)
+	token, err := clients.Add("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(fs, forms, oyster.NewSessions(time.Hour), clients)
+	server.origins = []string{"http://localhost:3000"}
+	return server, token
+}
+
+func serve(s *Server, method, path, token, session string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, "http://127.0.0.1:7878"+path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if session != "" {
+		req.Header.Set(sessionHeader, session)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestServer_auth(t *testing.T) {
+	s, token := setupServer(t)
+
+	if w := serve(s, "GET", "/forms", "", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", w.Code)
+	}
+	if w := serve(s, "GET", "/forms", "bogus", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with unknown token, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "http://127.0.0.1:7878/forms", nil)
+	req.Header.Set("Authorization", token)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the Bearer scheme, got %d", w.Code)
+	}
+	if w := serve(s, "GET", "/forms", token, "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "http://evil.example.com/forms", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for foreign Host, got %d", w.Code)
+	}
+
+	for origin, code := range map[string]int{
+		"http://localhost:3000": http.StatusNoContent,
+		"https://evil.example":  http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("OPTIONS", "http://localhost:7878/forms", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("Expected %d for origin %#v, got %d", code, origin, w.Code)
+		}
+	}
+}
+
+func TestServer_forms(t *testing.T) {
+	s, token := setupServer(t)
+	defer s.sessions.LockAll()
+
+	w := serve(s, "GET", "/forms/search?q=https://www.example.com/", token, "", nil)
+	var forms []oyster.Form
+	if err := json.NewDecoder(w.Body).Decode(&forms); err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 1 || forms[0].Key != "example.com" {
+		t.Errorf("Expected example.com, got %#v", forms)
+	}
+
+	if w := serve(s, "GET", "/forms/example.com", token, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without session, got %d", w.Code)
+	}
+	if w := serve(s, "POST", "/unlock", token, "", UnlockRequest{Passphrase: "wrong"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for bad passphrase, got %d", w.Code)
+	}
+	w = serve(s, "POST", "/unlock", token, "", UnlockRequest{Passphrase: "password"})
+	var session SessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(s, "GET", "/forms/example.com", token, session.Token, nil)
+	var form oyster.Form
+	if err := json.NewDecoder(w.Body).Decode(&form); err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Fields) != 1 || form.Fields[0].Value != "password123" {
+		t.Errorf("Expected decrypted form, got %#v", form)
+	}
+
+	put := oyster.Form{Fields: []oyster.Field{oyster.Field{Name: "password", Value: "hunter2"}}}
+	if w := serve(s, "PUT", "/forms/other.com", token, "", put); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if w := serve(s, "DELETE", "/forms/other.com", token, "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	f, err := s.files.Create("web/github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(s, "GET", "/forms/web", token, session.Token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a directory of password files, got %d", w.Code)
+	}
+	if w := serve(s, "DELETE", "/forms/web", token, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a directory of password files, got %d", w.Code)
+	}
+	if !s.store.IsFile("web/github") {
+		t.Error("Expected web/github to be kept")
+	}
+	if w := serve(s, "GET", "/forms/..%2fetc", token, session.Token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad key, got %d", w.Code)
+	}
+
+	if w := serve(s, "POST", "/lock", token, session.Token, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if w := serve(s, "GET", "/forms/example.com", token, session.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after lock, got %d", w.Code)
+	}
+}
+
+func TestServer_files(t *testing.T) {
+	s, token := setupServer(t)
+	defer s.sessions.LockAll()
+
+	put := oyster.Form{Fields: []oyster.Field{
+		oyster.Field{Name: "password", Value: "hunter2"},
+		oyster.Field{Name: "username", Value: "bob"},
+	}}
+	if w := serve(s, "PUT", "/files/work/email", token, "", put); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	w := serve(s, "GET", "/files", token, "", nil)
+	var keys []string
+	if err := json.NewDecoder(w.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if !contains(keys, "work/email") {
+		t.Errorf("Expected work/email, got %#v", keys)
+	}
+	w = serve(s, "GET", "/files?q=email", token, "", nil)
+	keys = nil
+	if err := json.NewDecoder(w.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "work/email" {
+		t.Errorf("Expected work/email, got %#v", keys)
+	}
+
+	w = serve(s, "POST", "/unlock", token, "", UnlockRequest{Passphrase: "password"})
+	var session SessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(s, "GET", "/files/work/email", token, session.Token, nil)
+	var form oyster.Form
+	if err := json.NewDecoder(w.Body).Decode(&form); err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Fields) != 2 || form.Fields[1].Value != "bob" {
+		t.Errorf("Expected decrypted fields, got %#v", form.Fields)
+	}
+	if w := serve(s, "DELETE", "/files/work/email", token, "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oyster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+
+	listener, err := listenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(socket); err != ErrSocketInUse {
+		t.Error("Expected ErrSocketInUse, got", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listenUnix(socket)
+	if err != nil {
+		t.Fatal("Expected a stale socket to be replaced, got", err)
+	}
+	listener.Close()
+
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(other); err != ErrNotSocket {
+		t.Error("Expected ErrNotSocket, got", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("Expected file to be kept, got", err)
+	}
+}
+
+func TestClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oyster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "clients")
+	clients := make(Clients)
+	token, err := clients.Add("editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients.Add("editor"); err != ErrClientExists {
+		t.Error("Expected ErrClientExists, got", err)
+	}
+	if err := clients.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	clients, err = ReadClients(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := clients.Authenticate(token); !ok || name != "editor" {
+		t.Errorf("Expected editor, got %#v", name)
+	}
+	if err := clients.Remove("editor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := clients.Authenticate(token); ok {
+		t.Error("Expected removed client to be rejected")
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/proglottis/oyster"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	sshPrefix    = "ssh/"
+	pubExtension = ".pub"
+)
+
+var (
+	ErrAgentReadOnly = errors.New("Keys are managed with `oyster put ssh/<name>`")
+	ErrAgentLocked   = errors.New("Agent is locked")
+	ErrAgentNoKey    = errors.New("No such key")
+	ErrAgentDenied   = errors.New("Use of key denied")
+)
+
+// sshAgent serves the private keys stored under ssh/ in a FileRepo. Keys
+// are decrypted for each signature and never kept, only the repository keys
+// are kept unlocked, for at most lifetime. The public keys are kept beside
+// them, unencrypted, so that listing keys only asks for the passphrase the
+// first time a key is listed.
+type sshAgent struct {
+	fs         *oyster.CryptoFS
+	repo       *oyster.FileRepo
+	passphrase func() ([]byte, error)
+	// confirm, when set, is asked before each use of a key.
+	confirm  func(key string) bool
+	lifetime time.Duration
+
+	// prompt serializes passphrase and confirmation prompts, which are
+	// never made with mu held so that a waiting prompt does not block
+	// other requests. use is held while the unlocked keys are in use, so
+	// that they are not wiped under a signature.
+	prompt   sync.Mutex
+	use      sync.RWMutex
+	mu       sync.Mutex
+	unlocked *oyster.CryptoFS
+	timer    *time.Timer
+	keys     map[string]ssh.PublicKey
+	lockedBy []byte
+}
+
+func newSSHAgent(fs *oyster.CryptoFS, passphrase func() ([]byte, error)) *sshAgent {
+	return &sshAgent{
+		fs:         fs,
+		repo:       oyster.NewFileRepo(fs),
+		passphrase: passphrase,
+		keys:       make(map[string]ssh.PublicKey),
+	}
+}
+
+// unlock returns the unlocked keys, asking for the passphrase when they
+// are not already unlocked.
+func (a *sshAgent) unlock() (*oyster.CryptoFS, error) {
+	a.prompt.Lock()
+	defer a.prompt.Unlock()
+	a.mu.Lock()
+	unlocked := a.unlocked
+	a.mu.Unlock()
+	if unlocked != nil {
+		return unlocked, nil
+	}
+	passphrase, err := a.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	unlocked, err = a.fs.Unlock(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lockedBy != nil {
+		unlocked.Lock()
+		return nil, ErrAgentLocked
+	}
+	a.unlocked = unlocked
+	if a.lifetime > 0 {
+		a.timer = time.AfterFunc(a.lifetime, a.forget)
+	}
+	return unlocked, nil
+}
+
+// withRepo calls fn with the unlocked repository, which is not locked
+// again until fn returns.
+func (a *sshAgent) withRepo(fn func(*oyster.FileRepo) error) error {
+	for {
+		unlocked, err := a.unlock()
+		if err != nil {
+			return err
+		}
+		a.use.RLock()
+		a.mu.Lock()
+		current := a.unlocked == unlocked
+		a.mu.Unlock()
+		if current {
+			defer a.use.RUnlock()
+			return fn(a.repo.Unlocked(unlocked))
+		}
+		// Forgotten before it could be used.
+		a.use.RUnlock()
+	}
+}
+
+// confirmUse asks whether key may be used, when confirmation is on.
+func (a *sshAgent) confirmUse(key string) bool {
+	if a.confirm == nil {
+		return true
+	}
+	a.prompt.Lock()
+	defer a.prompt.Unlock()
+	return a.confirm(key)
+}
+
+// forget locks the repository again, once nothing is using it.
+func (a *sshAgent) forget() {
+	a.mu.Lock()
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	unlocked := a.unlocked
+	a.unlocked = nil
+	a.mu.Unlock()
+	if unlocked != nil {
+		a.use.Lock()
+		unlocked.Lock()
+		a.use.Unlock()
+	}
+}
+
+func (a *sshAgent) signer(repo *oyster.FileRepo, key string) (ssh.Signer, error) {
+	plaintext, err := repo.Open(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Close()
+	pem, err := ioutil.ReadAll(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pem)
+}
+
+// pubName is the hidden file keeping the public key of key.
+func pubName(key string) string {
+	dir, base := path.Split(key)
+	return path.Join(dir, "."+base+pubExtension)
+}
+
+// cachedPublicKey reads the public key kept for key, unless key has been
+// replaced since it was written.
+func (a *sshAgent) cachedPublicKey(key string) ssh.PublicKey {
+	pubinfo, err := a.fs.Stat(pubName(key))
+	if err != nil {
+		return nil
+	}
+	keyinfo, err := a.fs.Stat(key + ".gpg")
+	if err != nil || keyinfo.ModTime().After(pubinfo.ModTime()) {
+		return nil
+	}
+	f, err := a.fs.Open(pubName(key))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+func (a *sshAgent) cachePublicKey(key string, pub ssh.PublicKey) error {
+	f, err := a.fs.Create(pubName(key))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(ssh.MarshalAuthorizedKey(pub)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *sshAgent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	locked := a.lockedBy != nil
+	a.mu.Unlock()
+	if locked {
+		return nil, nil
+	}
+	var names []string
+	err := a.repo.Walk(func(key string) {
+		if strings.HasPrefix(key, sshPrefix) {
+			names = append(names, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*agent.Key, 0, len(names))
+	for _, name := range names {
+		a.mu.Lock()
+		pub, ok := a.keys[name]
+		a.mu.Unlock()
+		if !ok {
+			pub = a.cachedPublicKey(name)
+		}
+		if !ok && pub == nil {
+			err := a.withRepo(func(repo *oyster.FileRepo) error {
+				signer, err := a.signer(repo, name)
+				if err != nil {
+					return nil
+				}
+				pub = signer.PublicKey()
+				return a.cachePublicKey(name, pub)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if pub == nil {
+				continue
+			}
+		}
+		if !ok {
+			a.mu.Lock()
+			a.keys[name] = pub
+			a.mu.Unlock()
+		}
+		keys = append(keys, &agent.Key{
+			Format:  pub.Type(),
+			Blob:    pub.Marshal(),
+			Comment: strings.TrimPrefix(name, sshPrefix),
+		})
+	}
+	return keys, nil
+}
+
+// keyName finds the name of a key listed earlier.
+func (a *sshAgent) keyName(key ssh.PublicKey) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lockedBy != nil {
+		return "", ErrAgentLocked
+	}
+	for name, pub := range a.keys {
+		if bytes.Equal(pub.Marshal(), key.Marshal()) {
+			return name, nil
+		}
+	}
+	return "", ErrAgentNoKey
+}
+
+func (a *sshAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *sshAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	name, err := a.keyName(key)
+	if err != nil {
+		return nil, err
+	}
+	if !a.confirmUse(name) {
+		return nil, ErrAgentDenied
+	}
+	var signer ssh.Signer
+	err = a.withRepo(func(repo *oyster.FileRepo) error {
+		var err error
+		signer, err = a.signer(repo, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	algorithm := ""
+	switch {
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		algorithm = ssh.KeyAlgoRSASHA256
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		algorithm = ssh.KeyAlgoRSASHA512
+	}
+	if s, ok := signer.(ssh.AlgorithmSigner); ok && algorithm != "" {
+		return s.SignWithAlgorithm(rand.Reader, data, algorithm)
+	}
+	return signer.Sign(rand.Reader, data)
+}
+
+func (a *sshAgent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	if a.lockedBy != nil {
+		a.mu.Unlock()
+		return ErrAgentLocked
+	}
+	a.lockedBy = append([]byte{}, passphrase...)
+	a.mu.Unlock()
+	a.forget()
+	return nil
+}
+
+func (a *sshAgent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lockedBy == nil || !bytes.Equal(a.lockedBy, passphrase) {
+		return ErrAgentLocked
+	}
+	a.lockedBy = nil
+	return nil
+}
+
+func (a *sshAgent) Add(key agent.AddedKey) error {
+	return ErrAgentReadOnly
+}
+
+func (a *sshAgent) Remove(key ssh.PublicKey) error {
+	return ErrAgentReadOnly
+}
+
+func (a *sshAgent) RemoveAll() error {
+	a.forget()
+	return nil
+}
+
+// Signers is only used by in-process clients, which would hold decrypted
+// keys, so it is not supported.
+func (a *sshAgent) Signers() ([]ssh.Signer, error) {
+	return nil, ErrAgentReadOnly
+}
+
+func (a *sshAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func setupSSHAgent(t testing.TB) (*sshAgent, ssh.PublicKey, *int) {
+	s, _ := setupServer(t)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.files.Create("ssh/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pem.Encode(w, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompts := 0
+	a := newSSHAgent(s.fs, func() ([]byte, error) {
+		prompts++
+		return []byte("password"), nil
+	})
+	return a, pub, &prompts
+}
+
+// dialSSH runs an SSH handshake between an in-process client authenticating
+// through the agent and a server that only accepts pub.
+func dialSSH(t testing.TB, a agent.Agent, pub ssh.PublicKey) error {
+	agentServer, agentClient := net.Pipe()
+	go agent.ServeAgent(a, agentServer)
+	defer agentClient.Close()
+	signers := agent.NewClient(agentClient).Signers
+
+	hostKey, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(pub.Marshal()) {
+				return nil, nil
+			}
+			return nil, ErrAgentNoKey
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+		conn, _, _, err := ssh.NewServerConn(serverConn, config)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	conn, _, _, err := ssh.NewClientConn(clientConn, "oyster", &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(signers)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func TestSSHAgent(t *testing.T) {
+	a, pub, prompts := setupSSHAgent(t)
+
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+	if *prompts != 1 {
+		t.Errorf("Expected a single passphrase prompt, got %d", *prompts)
+	}
+
+	keys, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "test" {
+		t.Errorf("Expected key 'test', got %#v", keys)
+	}
+
+	if err := a.Lock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialSSH(t, a, pub); err == nil {
+		t.Error("Expected locked agent to fail authentication")
+	}
+	if err := a.Unlock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+	if *prompts != 2 {
+		t.Errorf("Expected locking to forget the passphrase, got %d prompts", *prompts)
+	}
+}
+
+func TestSSHAgent_listCached(t *testing.T) {
+	a, pub, prompts := setupSSHAgent(t)
+	if _, err := a.List(); err != nil {
+		t.Fatal(err)
+	}
+	if *prompts != 1 {
+		t.Errorf("Expected the first listing to prompt, got %d prompts", *prompts)
+	}
+
+	restarted := newSSHAgent(a.fs, func() ([]byte, error) {
+		t.Error("Expected listing not to prompt again")
+		return []byte("password"), nil
+	})
+	keys, err := restarted.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || string(keys[0].Blob) != string(pub.Marshal()) {
+		t.Errorf("Expected the cached key, got %#v", keys)
+	}
+}
+
+func TestSSHAgent_confirm(t *testing.T) {
+	a, pub, _ := setupSSHAgent(t)
+	var asked []string
+	allow := false
+	a.confirm = func(key string) bool {
+		asked = append(asked, key)
+		return allow
+	}
+	if err := dialSSH(t, a, pub); err == nil {
+		t.Error("Expected denied key to fail authentication")
+	}
+	allow = true
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 2 || asked[0] != "ssh/test" {
+		t.Errorf("Expected confirmation for ssh/test twice, got %#v", asked)
+	}
+}
+
+func TestSSHAgent_lifetime(t *testing.T) {
+	a, pub, prompts := setupSSHAgent(t)
+	a.lifetime = 10 * time.Millisecond
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+	if *prompts != 2 {
+		t.Errorf("Expected passphrase to expire, got %d prompts", *prompts)
+	}
+}
+
+func TestSSHAgent_promptUnlocked(t *testing.T) {
+	a, pub, _ := setupSSHAgent(t)
+	a.confirm = func(key string) bool {
+		done := make(chan struct{})
+		go func() {
+			a.Unlock([]byte("secret"))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Expected other requests to be served while confirming")
+		}
+		return true
+	}
+	if err := dialSSH(t, a, pub); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorDir   = "\x1b[1;34m"
+	colorForm  = "\x1b[32m"
+)
+
+// filterEntries keeps the entries under prefix. A prefix containing glob
+// characters is matched against the key and each of its parent keys.
+func filterEntries(entries oyster.EntrySlice, prefix string) oyster.EntrySlice {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return entries
+	}
+	glob := strings.ContainsAny(prefix, "*?[")
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if glob && matchParents(prefix, entry.Key) ||
+			!glob && (entry.Key == prefix || strings.HasPrefix(entry.Key, prefix+"/")) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// filterTagged keeps the entries, files or forms, tagged with tag.
+func filterTagged(store *oyster.Store, entries oyster.EntrySlice, tag string, passphrase []byte) (oyster.EntrySlice, error) {
+	tagged := entries[:0]
+	for _, entry := range entries {
+		meta, err := store.Meta(entry, passphrase)
+		switch err {
+		case oyster.ErrNotFound: // Ignore
+		case nil:
+			if meta.HasTag(tag) {
+				tagged = append(tagged, entry)
+			}
+		default:
+			return nil, err
+		}
+	}
+	return tagged, nil
+}
+
+func matchParents(pattern, key string) bool {
+	parts := strings.Split(key, "/")
+	for i := len(parts); i > 0; i-- {
+		if ok, _ := path.Match(pattern, strings.Join(parts[:i], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// limitDepth cuts keys down to at most depth levels, like the tree drawn
+// with the same depth, dropping the duplicates this leaves.
+func limitDepth(keys []string, depth int) []string {
+	if depth < 1 {
+		return keys
+	}
+	limited := make([]string, 0, len(keys))
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if parts := strings.Split(key, "/"); len(parts) > depth {
+			key = strings.Join(parts[:depth], "/")
+		}
+		if !seen[key] {
+			seen[key] = true
+			limited = append(limited, key)
+		}
+	}
+	return limited
+}
+
+type treeNode struct {
+	children map[string]*treeNode
+	form     bool
+}
+
+func newTreeNode() *treeNode {
+	return &treeNode{children: make(map[string]*treeNode)}
+}
+
+func buildTree(entries oyster.EntrySlice) *treeNode {
+	root := newTreeNode()
+	for _, entry := range entries {
+		node := root
+		for _, part := range strings.Split(entry.Key, "/") {
+			child, ok := node.children[part]
+			if !ok {
+				child = newTreeNode()
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.form = entry.Form
+	}
+	return root
+}
+
+// Tree renders entries like `tree`, to at most depth levels when depth is
+// positive. Directories and forms are coloured when color is set.
+func Tree(w io.Writer, title string, entries oyster.EntrySlice, depth int, color bool) error {
+	if _, err := fmt.Fprintln(w, title); err != nil {
+		return err
+	}
+	return writeTree(w, buildTree(entries), "", 1, depth, color)
+}
+
+func writeTree(w io.Writer, node *treeNode, indent string, level, depth int, color bool) error {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		child := node.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		label := name
+		if color {
+			switch {
+			case child.form:
+				label = colorForm + name + colorReset
+			case len(child.children) > 0:
+				label = colorDir + name + colorReset
+			}
+		}
+		if _, err := fmt.Fprintln(w, indent+branch+label); err != nil {
+			return err
+		}
+		if depth > 0 && level >= depth {
+			continue
+		}
+		if err := writeTree(w, child, indent+next, level+1, depth, color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/proglottis/oyster"
+)
+
+var testEntries = oyster.EntrySlice{
+	oyster.Entry{Key: "email"},
+	oyster.Entry{Key: "work/example.com", Form: true},
+	oyster.Entry{Key: "work/vpn"},
+	oyster.Entry{Key: "work/servers/db"},
+}
+
+func TestTree(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Tree(&buf, "Oyster", testEntries, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Oyster
+├── email
+└── work
+    ├── example.com
+    ├── servers
+    │   └── db
+    └── vpn
+`
+	if buf.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := Tree(&buf, "Oyster", testEntries, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	expected = "Oyster\n├── email\n└── " + colorDir + "work" + colorReset + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestFilterEntries(t *testing.T) {
+	for prefix, expected := range map[string][]string{
+		"":        []string{"email", "work/example.com", "work/vpn", "work/servers/db"},
+		"work/":   []string{"work/example.com", "work/vpn", "work/servers/db"},
+		"wor":     nil,
+		"work/s*": []string{"work/servers/db"},
+		"*/vpn":   []string{"work/vpn"},
+		"e?ail":   []string{"email"},
+	} {
+		entries := append(oyster.EntrySlice{}, testEntries...)
+		keys := filterEntries(entries, prefix).Keys()
+		if len(keys) == 0 {
+			keys = nil
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Expected %#v for %#v, got %#v", expected, prefix, keys)
+		}
+	}
+}
+
+func TestFilterTagged(t *testing.T) {
+	s, _ := setupServer(t)
+	store := oyster.NewStore(s.fs)
+	w, err := store.Files.Create("vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []oyster.Entry{{Key: "vpn"}, {Key: "example.com", Form: true}} {
+		meta := &oyster.Meta{Tags: []string{"work"}}
+		if entry.Form {
+			err = store.Forms.SetMeta(entry.Key, meta)
+		} else {
+			err = store.Files.SetMeta(entry.Key, meta)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.List(true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := filterTagged(store, entries, "work", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := oyster.EntrySlice{{Key: "example.com", Form: true}, {Key: "vpn"}}
+	if !reflect.DeepEqual(tagged, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, tagged)
+	}
+}
+
+func TestLimitDepth(t *testing.T) {
+	keys := []string{"example.com", "work/email", "work/vpn", "work/ssh/deploy"}
+	expected := []string{"example.com", "work"}
+	if limited := limitDepth(keys, 1); !reflect.DeepEqual(limited, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, limited)
+	}
+	expected = []string{"example.com", "work/email", "work/vpn", "work/ssh"}
+	if limited := limitDepth(keys, 2); !reflect.DeepEqual(limited, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, limited)
+	}
+	if limited := limitDepth(keys, 0); !reflect.DeepEqual(limited, keys) {
+		t.Errorf("Expected %#v, got %#v", keys, limited)
+	}
+}
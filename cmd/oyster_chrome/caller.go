@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	chromeOriginPrefix = "chrome-extension://"
+)
+
+var (
+	ErrUnknownCaller = errors.New("Unknown caller, the host must be started by a browser")
+	ErrNotAllowed    = errors.New("Caller is not allowed")
+)
+
+// Caller identifies the browser extension that started the host.
+type Caller struct {
+	// Browser is "chrome" for Chrome based browsers or "firefox".
+	Browser string
+	// Origin is the extension origin for Chrome and the extension ID for
+	// Firefox, as used in the manifest allow lists.
+	Origin string
+}
+
+// detectCaller works out the caller from the host's arguments. Chrome passes
+// the extension origin, followed on Windows by the parent window handle.
+// Firefox passes the path to the host manifest then the extension ID.
+func detectCaller(args []string) (*Caller, error) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, chromeOriginPrefix) {
+			return &Caller{Browser: "chrome", Origin: arg}, nil
+		}
+	}
+	if len(args) >= 2 && strings.HasSuffix(strings.ToLower(args[0]), ".json") && args[1] != "" {
+		return &Caller{Browser: "firefox", Origin: args[1]}, nil
+	}
+	return nil, ErrUnknownCaller
+}
+
+// allowedOrigins returns the configured allow list for browser.
+func allowedOrigins(config *oyster.Config, browser string) []string {
+	if browser == "firefox" {
+		return config.AllowedOrigins(browser, defaultFirefoxExtensions)
+	}
+	return config.AllowedOrigins(browser, defaultChromeOrigins)
+}
+
+func (c *Caller) allowedBy(origins []string) bool {
+	for _, origin := range origins {
+		if c.Origin == origin {
+			return true
+		}
+	}
+	return false
+}
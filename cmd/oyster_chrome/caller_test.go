@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectCaller(t *testing.T) {
+	for _, test := range []struct {
+		args    []string
+		browser string
+		origin  string
+	}{
+		{[]string{"chrome-extension://knchgkoimfkgfopjfehdkcchmbmkmfgi/"}, "chrome", "chrome-extension://knchgkoimfkgfopjfehdkcchmbmkmfgi/"},
+		{[]string{"chrome-extension://knchgkoimfkgfopjfehdkcchmbmkmfgi/", "--parent-window=0"}, "chrome", "chrome-extension://knchgkoimfkgfopjfehdkcchmbmkmfgi/"},
+		{[]string{"/home/bob/.mozilla/native-messaging-hosts/com.github.proglottis.oyster.json", "oyster@proglottis.github.com"}, "firefox", "oyster@proglottis.github.com"},
+	} {
+		caller, err := detectCaller(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if caller.Browser != test.browser || caller.Origin != test.origin {
+			t.Errorf("%v: expected %s %s, got %#v", test.args, test.browser, test.origin, caller)
+		}
+	}
+	for _, args := range [][]string{nil, []string{"install"}, []string{"manifest.json"}} {
+		if _, err := detectCaller(args); err != ErrUnknownCaller {
+			t.Errorf("%v: expected ErrUnknownCaller, got %v", args, err)
+		}
+	}
+}
+
+func TestCallerAllowedBy(t *testing.T) {
+	caller := &Caller{Browser: "firefox", Origin: "oyster@proglottis.github.com"}
+	if !caller.allowedBy(defaultFirefoxExtensions) {
+		t.Error("Expected default extension to be allowed")
+	}
+	if caller.allowedBy(defaultChromeOrigins) {
+		t.Error("Expected Chrome origins not to allow a Firefox extension")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	CodeBadPassphrase  = "BAD_PASSPHRASE"
+	CodeNoMatchingKeys = "NO_MATCHING_KEYS"
+	CodeNotFound       = "NOT_FOUND"
+	CodeBadRequest     = "BAD_REQUEST"
+	CodeUnknownRequest = "UNKNOWN_REQUEST"
+	CodeTooLarge       = "TOO_LARGE"
+	CodeCrossOrigin    = "CROSS_ORIGIN"
+	CodeConfirm        = "CONFIRMATION_REQUIRED"
+	CodeInvalidSession = "INVALID_SESSION"
+	CodeCancelled      = "CANCELLED"
+	CodeIO             = "IO"
+	CodeInternal       = "INTERNAL"
+)
+
+var (
+	ErrUnknownRequest     = errors.New("Unknown request type")
+	ErrUnsupportedVersion = errors.New("Unsupported protocol version")
+)
+
+// ErrorData is the structured ERROR response sent to version 2 clients.
+// Codes are stable so that clients can act on them, for example only
+// prompting again for a passphrase on BAD_PASSPHRASE.
+type ErrorData struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Key       string `json:"key,omitempty"`
+}
+
+func errorCode(err error) (code string, retryable bool) {
+	switch err {
+	case oyster.ErrCannotDecryptKey:
+		return CodeBadPassphrase, true
+	case oyster.ErrNoMatchingKeys:
+		return CodeNoMatchingKeys, false
+	case oyster.ErrNotFound:
+		return CodeNotFound, false
+	case oyster.ErrInvalidField, oyster.ErrInvalidAttachment:
+		return CodeBadRequest, false
+	case ErrUnknownRequest:
+		return CodeUnknownRequest, false
+	case ErrUnsupportedVersion:
+		return CodeBadRequest, false
+	case ErrMessageTooLarge:
+		return CodeTooLarge, false
+	case ErrCrossOrigin:
+		return CodeCrossOrigin, false
+	case ErrConfirmationRequired:
+		return CodeConfirm, true
+	case oyster.ErrInvalidSession:
+		return CodeInvalidSession, true
+	case oyster.ErrPinentryCancelled:
+		return CodeCancelled, true
+	}
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return CodeBadRequest, false
+	case *os.PathError, *os.LinkError, *os.SyscallError:
+		return CodeIO, true
+	}
+	return CodeInternal, false
+}
+
+func newErrorData(err error, key string) ErrorData {
+	code, retryable := errorCode(err)
+	return ErrorData{
+		Code:      code,
+		Message:   err.Error(),
+		Retryable: retryable,
+		Key:       key,
+	}
+}
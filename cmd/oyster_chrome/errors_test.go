@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/proglottis/oyster"
+)
+
+func TestErrorCode(t *testing.T) {
+	jsonErr := json.Unmarshal([]byte("{]"), &struct{}{})
+	_, ioErr := os.Open("does/not/exist")
+	for _, test := range []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{oyster.ErrCannotDecryptKey, CodeBadPassphrase, true},
+		{oyster.ErrNoMatchingKeys, CodeNoMatchingKeys, false},
+		{oyster.ErrNotFound, CodeNotFound, false},
+		{ErrUnknownRequest, CodeUnknownRequest, false},
+		{jsonErr, CodeBadRequest, false},
+		{ioErr, CodeIO, true},
+		{errors.New("Something else"), CodeInternal, false},
+	} {
+		code, retryable := errorCode(test.err)
+		if code != test.code || retryable != test.retryable {
+			t.Errorf("%v: expected %s/%v, got %s/%v", test.err, test.code, test.retryable, code, retryable)
+		}
+	}
+}
+
+func TestRequestHandler_error_data(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	responses := runRequests(t, h, requests, buf,
+		request(1, "HELLO", HelloData{Version: 2}),
+		request(2, "GET", GetData{Origin: optionsPage, Key: "example.com", Passphrase: "wrong"}),
+		request(3, "GET", GetData{Origin: optionsPage, Key: "missing.com", Passphrase: "password"}),
+	)
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(responses))
+	}
+	expected := map[uint64]ErrorData{
+		2: ErrorData{Code: CodeBadPassphrase, Retryable: true, Key: "example.com"},
+		3: ErrorData{Code: CodeNotFound, Key: "missing.com"},
+	}
+	for _, response := range responses[1:] {
+		if response.Type != "ERROR" {
+			t.Fatalf("Expected ERROR, got %#v", response.Type)
+		}
+		var data ErrorData
+		if err := json.Unmarshal(response.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		want := expected[response.ID]
+		if data.Code != want.Code || data.Retryable != want.Retryable || data.Key != want.Key {
+			t.Errorf("Expected %#v, got %#v", want, data)
+		}
+		if data.Message == "" {
+			t.Error("Expected a message")
+		}
+	}
+}
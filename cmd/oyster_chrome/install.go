@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/proglottis/oyster"
+)
+
+const (
+	hostName        = "com.github.proglottis.oyster"
+	hostDescription = "Oyster"
+)
+
+var (
+	defaultChromeOrigins = []string{
+		"chrome-extension://knchgkoimfkgfopjfehdkcchmbmkmfgi/",
+		"chrome-extension://hnpojabeaplfmgmjgdmemhnccpcpkifm/",
+	}
+	// The gecko ID set in the extension's manifest.json.
+	defaultFirefoxExtensions = []string{
+		"oyster@proglottis.github.com",
+	}
+)
+
+var (
+	ErrNoBrowsers          = errors.New("No supported browsers found, choose one with --browser")
+	ErrUnsupportedPlatform = errors.New("Installing is not supported on this platform")
+)
+
+// Manifest is the native messaging host manifest read by the browser.
+// Chrome based browsers use AllowedOrigins, Firefox AllowedExtensions.
+type Manifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+}
+
+type Browser struct {
+	Name    string
+	Firefox bool
+	// Dir is where the browser looks for host manifests, relative to the
+	// home directory.
+	Dir string
+}
+
+// family is the name used for the browser's allow list, Chrome based
+// browsers share one.
+func (b Browser) family() string {
+	if b.Firefox {
+		return "firefox"
+	}
+	return "chrome"
+}
+
+func (b Browser) manifest(binary string, origins []string) *Manifest {
+	m := &Manifest{
+		Name:        hostName,
+		Description: hostDescription,
+		Path:        binary,
+		Type:        "stdio",
+	}
+	if b.Firefox {
+		m.AllowedExtensions = origins
+	} else {
+		m.AllowedOrigins = origins
+	}
+	return m
+}
+
+func findBrowser(name string) (Browser, bool) {
+	for _, b := range browsers {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Browser{}, false
+}
+
+type installer struct {
+	home   string
+	binary string
+	config *oyster.Config
+	dryRun bool
+	out    io.Writer
+}
+
+// selectBrowsers returns the named browsers or, when none are named, every
+// browser that appears to be installed for the user.
+func (i *installer) selectBrowsers(names string) ([]Browser, error) {
+	var selected []Browser
+	if names != "" {
+		for _, name := range strings.Split(names, ",") {
+			b, ok := findBrowser(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("Unknown browser %s", name)
+			}
+			selected = append(selected, b)
+		}
+		return selected, nil
+	}
+	for _, b := range browsers {
+		if _, err := os.Stat(filepath.Dir(filepath.Join(i.home, b.Dir))); err == nil {
+			selected = append(selected, b)
+		}
+	}
+	if len(selected) < 1 {
+		return nil, ErrNoBrowsers
+	}
+	return selected, nil
+}
+
+func (i *installer) manifestPath(b Browser) string {
+	return filepath.Join(i.home, b.Dir, hostName+".json")
+}
+
+func (i *installer) install(b Browser) error {
+	origins := allowedOrigins(i.config, b.family())
+	buf, err := json.MarshalIndent(b.manifest(i.binary, origins), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := i.manifestPath(b)
+	fmt.Fprintf(i.out, "Installing %s manifest %s\n", b.Name, path)
+	if i.dryRun {
+		fmt.Fprintf(i.out, "%s\n", buf)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0644)
+}
+
+func (i *installer) uninstall(b Browser) error {
+	path := i.manifestPath(b)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	fmt.Fprintf(i.out, "Removing %s manifest %s\n", b.Name, path)
+	if i.dryRun {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+func binaryPath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// runInstall implements the install and uninstall subcommands, which
+// register this binary as a native messaging host with each browser.
+func runInstall(cmd string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.SetOutput(out)
+	dryRun := flags.Bool("dry-run", false, "print what would be done without changing anything")
+	names := flags.String("browser", "", "comma separated browsers (chrome, chromium, brave, firefox)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(browsers) < 1 {
+		return ErrUnsupportedPlatform
+	}
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	binary, err := binaryPath()
+	if err != nil {
+		return err
+	}
+	config, err := oyster.ReadConfig()
+	if err != nil {
+		return err
+	}
+	i := &installer{home: u.HomeDir, binary: binary, config: config, dryRun: *dryRun, out: out}
+	selected, err := i.selectBrowsers(*names)
+	if err != nil {
+		return err
+	}
+	for _, b := range selected {
+		if cmd == "uninstall" {
+			err = i.uninstall(b)
+		} else {
+			err = i.install(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
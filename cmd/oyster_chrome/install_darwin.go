@@ -0,0 +1,8 @@
+package main
+
+var browsers = []Browser{
+	{Name: "chrome", Dir: "Library/Application Support/Google/Chrome/NativeMessagingHosts"},
+	{Name: "chromium", Dir: "Library/Application Support/Chromium/NativeMessagingHosts"},
+	{Name: "brave", Dir: "Library/Application Support/BraveSoftware/Brave-Browser/NativeMessagingHosts"},
+	{Name: "firefox", Firefox: true, Dir: "Library/Application Support/Mozilla/NativeMessagingHosts"},
+}
@@ -0,0 +1,8 @@
+package main
+
+var browsers = []Browser{
+	{Name: "chrome", Dir: ".config/google-chrome/NativeMessagingHosts"},
+	{Name: "chromium", Dir: ".config/chromium/NativeMessagingHosts"},
+	{Name: "brave", Dir: ".config/BraveSoftware/Brave-Browser/NativeMessagingHosts"},
+	{Name: "firefox", Firefox: true, Dir: ".mozilla/native-messaging-hosts"},
+}
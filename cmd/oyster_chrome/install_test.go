@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/proglottis/oyster"
+)
+
+func setupInstaller(t *testing.T, dryRun bool) *installer {
+	home, err := ioutil.TempDir("", "oyster-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &installer{
+		home:   home,
+		binary: "/usr/local/bin/oyster_chrome",
+		config: oyster.NewConfig(),
+		dryRun: dryRun,
+		out:    &bytes.Buffer{},
+	}
+}
+
+func TestInstallerSelectBrowsers(t *testing.T) {
+	i := setupInstaller(t, false)
+	defer os.RemoveAll(i.home)
+
+	if _, err := i.selectBrowsers(""); err != ErrNoBrowsers {
+		t.Errorf("Expected ErrNoBrowsers, got %v", err)
+	}
+	firefox, _ := findBrowser("firefox")
+	os.MkdirAll(filepath.Dir(filepath.Join(i.home, firefox.Dir)), 0755)
+	selected, err := i.selectBrowsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || selected[0].Name != "firefox" {
+		t.Errorf("Expected only firefox, got %#v", selected)
+	}
+	selected, err = i.selectBrowsers("chrome,brave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 {
+		t.Errorf("Expected 2 browsers, got %#v", selected)
+	}
+	if _, err := i.selectBrowsers("netscape"); err == nil {
+		t.Error("Expected an unknown browser error")
+	}
+}
+
+func TestInstallerInstall(t *testing.T) {
+	i := setupInstaller(t, false)
+	defer os.RemoveAll(i.home)
+
+	for _, b := range browsers {
+		if err := i.install(b); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadFile(i.manifestPath(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Manifest
+		if err := json.Unmarshal(buf, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Name != hostName || m.Path != i.binary || m.Type != "stdio" {
+			t.Errorf("Unexpected %s manifest %#v", b.Name, m)
+		}
+		if b.Firefox && (len(m.AllowedExtensions) < 1 || len(m.AllowedOrigins) > 0) {
+			t.Errorf("Expected only allowed_extensions for %s, got %#v", b.Name, m)
+		}
+		if !b.Firefox && (len(m.AllowedOrigins) < 1 || len(m.AllowedExtensions) > 0) {
+			t.Errorf("Expected only allowed_origins for %s, got %#v", b.Name, m)
+		}
+
+		if err := i.uninstall(b); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(i.manifestPath(b)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s manifest to be removed, got %v", b.Name, err)
+		}
+	}
+}
+
+func TestInstallerInstall_dry_run(t *testing.T) {
+	i := setupInstaller(t, true)
+	defer os.RemoveAll(i.home)
+
+	for _, b := range browsers {
+		if err := i.install(b); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(i.manifestPath(b)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s manifest, got %v", b.Name, err)
+		}
+	}
+	if !bytes.Contains(i.out.(*bytes.Buffer).Bytes(), []byte(i.binary)) {
+		t.Error("Expected the manifest to be printed")
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// Windows browsers find host manifests through the registry rather than in
+// a directory, so there is nothing to install to.
+var browsers []Browser
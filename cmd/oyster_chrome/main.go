@@ -2,189 +2,597 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
+	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/proglottis/oyster"
 	"github.com/sourcegraph/rwvfs"
 )
 
+const (
+	protocolVersion = 2
+	maxWorkers      = 4
+)
+
+// Message is the envelope of every request and response. Version 2 clients
+// set ID, which is echoed in the response to that request.
 type Message struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	ID    uint64          `json:"id,omitempty"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+	Page  int             `json:"page,omitempty"`
+	Pages int             `json:"pages,omitempty"`
+	err   error
+}
+
+type HelloData struct {
+	Version int `json:"version"`
+	// Pinentry is set in responses when the host prompts for passphrases
+	// itself, so that they need not be sent.
+	Pinentry bool `json:"pinentry,omitempty"`
+}
+
+type ListData struct {
+	Origin
+	Passphrase string `json:"passphrase,omitempty"`
 }
 
 type SearchData struct {
-	Query string `json:"query"`
+	Origin
+	Query      string `json:"query"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// Token is a session from UNLOCK. Equivalent domains are encrypted and
+	// only searched with one.
+	Token string `json:"token,omitempty"`
 }
 
 type GetData struct {
 	Key        string `json:"key"`
-	Passphrase string `json:"passphrase"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// Token is a session from UNLOCK, used instead of the passphrase.
+	Token string `json:"token,omitempty"`
+	// Origin is the page that will be filled. Confirmed is set once the
+	// user has agreed to fill it with a form saved for another site.
+	Origin
+	Confirmed bool `json:"confirmed,omitempty"`
 }
 
 type DeleteData struct {
 	Key string `json:"key"`
 }
 
+// PatchData names fields to write into an existing form. Fields not named
+// are left as they are.
+type PatchData struct {
+	Key    string         `json:"key"`
+	Fields []oyster.Field `json:"fields"`
+}
+
+type DeleteFieldData struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type UnlockData struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// SessionData is the response to UNLOCK. The token is valid for TTL seconds
+// or until a LOCK request.
+type SessionData struct {
+	Token string `json:"token"`
+	TTL   int    `json:"ttl"`
+}
+
+type LockData struct {
+	Token string `json:"token"`
+}
+
+// readRequests decodes requests until the stream ends. Requests that cannot
+// be decoded are passed on with their error so that they can be answered.
 func readRequests(r io.Reader, requests chan<- *Message) {
+	defer close(requests)
 	dec := NewDecoder(r)
 	for {
 		var req Message
 		if err := dec.Decode(&req); err != nil {
-			switch err {
-			case io.EOF:
-				close(requests)
-				return
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				req.err = err
 			default:
-				panic(err)
+				if err != ErrMessageTooLarge {
+					return
+				}
+				req.err = err
 			}
 		}
-		select {
-		case requests <- &req:
-		}
+		requests <- &req
 	}
 }
 
 type RequestHandler struct {
 	requests <-chan *Message
+	quit     chan struct{}
 	enc      *Encoder
 	repo     *oyster.FormRepo
+	fs       *oyster.CryptoFS
+	// store tells forms apart from directories of password files, which
+	// share the repository.
+	store    *oyster.Store
+	sessions *oyster.Sessions
+	// prompt asks for passphrases that requests leave empty, when set.
+	// promptMu serializes the prompts, which are made without holding mu.
+	prompt   oyster.PassphraseSource
+	promptMu sync.Mutex
+	policy   OriginPolicy
+	mu       sync.RWMutex
+	version  int
 }
 
 func (h *RequestHandler) Handle(req *Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.errorResponse(req, fmt.Errorf("Internal error: %v", r))
+		}
+	}()
+	if req.err != nil {
+		h.errorResponse(req, req.err)
+		return
+	}
+	switch req.Type {
+	case "HELLO", "PUT", "REMOVE", "PATCH_FIELDS", "DELETE_FIELD":
+		h.mu.Lock()
+		defer h.mu.Unlock()
+	default:
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+	}
 	switch req.Type {
+	case "HELLO":
+		var data HelloData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		if data.Version < 1 {
+			h.errorResponse(req, ErrUnsupportedVersion)
+			return
+		}
+		if data.Version > protocolVersion {
+			data.Version = protocolVersion
+		}
+		h.version = data.Version
+		data.Pinentry = h.prompt != nil
+		h.respond(req, "HELLO", data)
 	case "LIST":
+		var data ListData
+		if len(req.Data) > 0 {
+			if err := json.Unmarshal(req.Data, &data); err != nil {
+				h.errorResponse(req, err)
+				return
+			}
+		}
 		forms, err := h.repo.List()
+		if err == nil {
+			forms, err = filterOrigin(h.policy, h.repo, data.Origin, h.store.FilterForms(forms))
+		}
 		if err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
 			return
 		}
-		h.formsResponse(forms)
+		if err := h.loadMeta(forms, data.Passphrase); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		h.formsResponse(req, forms)
 	case "SEARCH":
 		var data SearchData
 		if err := json.Unmarshal(req.Data, &data); err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
 			return
 		}
-		forms, err := h.repo.Search(data.Query)
+		var forms []oyster.Form
+		err := h.withRepo(data.Token, func(repo *oyster.FormRepo) error {
+			var err error
+			if forms, err = repo.Search(data.Query); err != nil {
+				return err
+			}
+			forms, err = filterOrigin(h.policy, repo, data.Origin, h.store.FilterForms(forms))
+			return err
+		})
 		if err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
+			return
+		}
+		if err := h.loadMeta(forms, data.Passphrase); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		h.formsResponse(req, forms)
+	case "FIND":
+		var data SearchData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		matches, err := h.repo.Find(data.Query)
+		if err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		forms := make([]oyster.Form, 0, len(matches))
+		for _, match := range matches {
+			form, err := h.repo.Fields(match.Key)
+			if err != nil {
+				h.keyErrorResponse(req, match.Key, err)
+				return
+			}
+			forms = append(forms, *form)
+		}
+		if forms, err = filterOrigin(h.policy, h.repo, data.Origin, h.store.FilterForms(forms)); err != nil {
+			h.errorResponse(req, err)
 			return
 		}
-		h.formsResponse(forms)
+		h.formsResponse(req, forms)
 	case "GET":
 		var data GetData
 		if err := json.Unmarshal(req.Data, &data); err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
 			return
 		}
-		form, err := h.repo.Get(data.Key, []byte(data.Passphrase))
+		form, err := h.get(data)
 		if err != nil {
-			h.errorResponse(err)
+			h.keyErrorResponse(req, data.Key, err)
 			return
 		}
-		h.formResponse(form)
+		h.formResponse(req, form)
 	case "PUT":
 		var data oyster.Form
 		if err := json.Unmarshal(req.Data, &data); err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
 			return
 		}
 		if err := h.repo.Put(&data); err != nil {
-			h.errorResponse(err)
+			h.keyErrorResponse(req, data.Key, err)
 			return
 		}
-		h.okResponse()
+		h.okResponse(req)
 	case "REMOVE":
 		var data DeleteData
 		if err := json.Unmarshal(req.Data, &data); err != nil {
-			h.errorResponse(err)
+			h.errorResponse(req, err)
 			return
 		}
 		if err := h.repo.Remove(data.Key); err != nil {
-			h.errorResponse(err)
+			h.keyErrorResponse(req, data.Key, err)
+			return
+		}
+		h.okResponse(req)
+	case "PATCH_FIELDS":
+		var data PatchData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
 			return
 		}
-		h.okResponse()
+		if err := h.repo.PatchFields(data.Key, data.Fields); err != nil {
+			h.keyErrorResponse(req, data.Key, err)
+			return
+		}
+		h.okResponse(req)
+	case "DELETE_FIELD":
+		var data DeleteFieldData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		if err := h.repo.RemoveField(data.Key, data.Name); err != nil {
+			h.keyErrorResponse(req, data.Key, err)
+			return
+		}
+		h.okResponse(req)
+	case "UNLOCK":
+		var data UnlockData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		passphrase, err := h.passphrase(data.Passphrase)
+		if err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		token, err := h.sessions.Unlock(h.fs, passphrase)
+		if err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		h.respond(req, "SESSION", SessionData{
+			Token: token,
+			TTL:   int(h.sessions.TTL() / time.Second),
+		})
+	case "LOCK":
+		var data LockData
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		if err := h.sessions.Lock(data.Token); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
+		h.okResponse(req)
 	default:
-		h.errorResponse(errors.New("Unknown request type"))
+		h.errorResponse(req, ErrUnknownRequest)
 	}
 }
 
-func (h *RequestHandler) formsResponse(forms []oyster.Form) {
-	var err error
-	response := &Message{Type: "FORMS"}
-	response.Data, err = json.Marshal(forms)
-	if err != nil {
-		h.errorResponse(err)
+// withRepo calls fn with the repository unlocked by the session of token,
+// or with the locked repository when there is no token.
+func (h *RequestHandler) withRepo(token string, fn func(*oyster.FormRepo) error) error {
+	if token == "" {
+		return fn(h.repo)
 	}
-	if err := h.enc.Encode(response); err != nil {
-		h.errorResponse(err)
+	return h.sessions.Use(token, func(fs *oyster.CryptoFS) error {
+		return fn(h.repo.Unlocked(fs))
+	})
+}
+
+// get checks that the page may be filled with the form, then reads it with
+// the session keys of the request's token, or with its passphrase when it
+// has none.
+func (h *RequestHandler) get(data GetData) (*oyster.Form, error) {
+	if !h.store.IsForm(data.Key) {
+		return nil, oyster.ErrNotFound
+	}
+	var form *oyster.Form
+	err := h.withRepo(data.Token, func(repo *oyster.FormRepo) error {
+		if err := checkOrigin(h.policy, repo, data.Origin, data.Key, data.Confirmed); err != nil {
+			return err
+		}
+		var passphrase []byte
+		if data.Token == "" {
+			var err error
+			if passphrase, err = h.passphrase(data.Passphrase); err != nil {
+				return err
+			}
+		}
+		var err error
+		form, err = repo.Get(data.Key, passphrase)
+		return err
+	})
+	return form, err
+}
+
+// passphrase prompts for the passphrase when the request has none and the
+// host has a prompt. Callers hold mu for reading, which is released while
+// prompting so that a pending prompt does not hold up other requests.
+func (h *RequestHandler) passphrase(passphrase string) ([]byte, error) {
+	if passphrase != "" || h.prompt == nil {
+		return []byte(passphrase), nil
 	}
+	h.mu.RUnlock()
+	defer h.mu.RLock()
+	h.promptMu.Lock()
+	defer h.promptMu.Unlock()
+	return h.prompt.Passphrase()
 }
 
-func (h *RequestHandler) formResponse(form *oyster.Form) {
+// loadMeta attaches metadata to forms when the request carried a
+// passphrase to decrypt it with.
+func (h *RequestHandler) loadMeta(forms []oyster.Form, passphrase string) error {
+	if passphrase == "" {
+		return nil
+	}
+	return h.repo.LoadMeta(forms, []byte(passphrase))
+}
+
+func (h *RequestHandler) respond(req *Message, typ string, v interface{}) {
 	var err error
-	response := &Message{Type: "FORM"}
-	response.Data, err = json.Marshal(form)
+	response := &Message{ID: req.ID, Type: typ}
+	response.Data, err = json.Marshal(v)
 	if err != nil {
-		h.errorResponse(err)
+		h.errorResponse(req, err)
+		return
 	}
 	if err := h.enc.Encode(response); err != nil {
-		h.errorResponse(err)
+		h.errorResponse(req, err)
 	}
 }
 
-func (h *RequestHandler) okResponse() {
-	var err error
-	response := &Message{Type: "OK"}
-	response.Data, err = json.Marshal(map[string]interface{}{})
+// formsResponse sends forms in as many FORMS pages as are needed to keep
+// each message within the size limit. A single page is sent without page
+// numbers. Only clients that negotiated version 2 read more than one
+// message per request, so others get an error instead of a partial list.
+func (h *RequestHandler) formsResponse(req *Message, forms []oyster.Form) {
+	pages, err := h.paginate(forms)
 	if err != nil {
-		h.errorResponse(err)
+		h.errorResponse(req, err)
+		return
 	}
-	if err := h.enc.Encode(response); err != nil {
-		h.errorResponse(err)
+	if len(pages) == 1 {
+		h.respond(req, "FORMS", pages[0])
+		return
+	}
+	if h.version < 2 {
+		h.errorResponse(req, ErrMessageTooLarge)
+		return
+	}
+	for i, page := range pages {
+		response := &Message{ID: req.ID, Type: "FORMS", Data: page, Page: i + 1, Pages: len(pages)}
+		if err := h.enc.Encode(response); err != nil {
+			h.errorResponse(req, err)
+			return
+		}
 	}
 }
 
-func (h *RequestHandler) errorResponse(err error) {
+func (h *RequestHandler) paginate(forms []oyster.Form) ([]json.RawMessage, error) {
+	// Leave room for the envelope around each page.
+	limit := h.enc.MaxSize - 128
+	pages := make([]json.RawMessage, 0, 1)
+	page := json.RawMessage("[")
+	for _, form := range forms {
+		buf, err := json.Marshal(form)
+		if err != nil {
+			return nil, err
+		}
+		if len(buf)+2 > limit {
+			return nil, ErrMessageTooLarge
+		}
+		if len(page)+len(buf)+1 > limit {
+			pages = append(pages, append(page, ']'))
+			page = json.RawMessage("[")
+		}
+		if len(page) > 1 {
+			page = append(page, ',')
+		}
+		page = append(page, buf...)
+	}
+	return append(pages, append(page, ']')), nil
+}
+
+func (h *RequestHandler) formResponse(req *Message, form *oyster.Form) {
+	h.respond(req, "FORM", form)
+}
+
+func (h *RequestHandler) okResponse(req *Message) {
+	h.respond(req, "OK", map[string]interface{}{})
+}
+
+func (h *RequestHandler) errorResponse(req *Message, err error) {
+	h.keyErrorResponse(req, "", err)
+}
+
+// keyErrorResponse reports err as it relates to key. Version 1 clients only
+// receive the error message.
+func (h *RequestHandler) keyErrorResponse(req *Message, key string, err error) {
 	var e error
-	response := &Message{Type: "ERROR"}
-	response.Data, e = json.Marshal(err.Error())
-	if e != nil {
-		panic(e)
+	response := &Message{ID: req.ID, Type: "ERROR"}
+	if h.version < 2 {
+		response.Data, e = json.Marshal(err.Error())
+	} else {
+		response.Data, e = json.Marshal(newErrorData(err, key))
 	}
-	if err := h.enc.Encode(response); err != nil {
-		panic(err)
+	if e == nil {
+		e = h.enc.Encode(response)
+	}
+	if e != nil {
+		log.Printf("Cannot send error %q: %v", err, e)
 	}
 }
 
+// Run handles requests until the requests channel is closed or Shutdown is
+// called, then waits for requests in progress. Requests are handled one at
+// a time, in order, until a HELLO negotiates version 2 after which they are
+// handled concurrently by a bounded number of workers.
 func (h *RequestHandler) Run() error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	workers := make(chan struct{}, maxWorkers)
 	for {
+		var req *Message
 		select {
-		case req := <-h.requests:
+		case r, ok := <-h.requests:
+			if !ok {
+				return nil
+			}
+			req = r
+		case <-h.quit:
+			return nil
+		}
+		if req.Type == "HELLO" {
+			wg.Wait()
+		}
+		if h.version < 2 || req.Type == "HELLO" {
 			h.Handle(req)
+			continue
 		}
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(req *Message) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			h.Handle(req)
+		}(req)
 	}
 }
 
+// Shutdown stops Run from handling any further requests.
+func (h *RequestHandler) Shutdown() {
+	close(h.quit)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "install", "uninstall":
+			if err := runInstall(os.Args[1], os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Chrome closing the pipe must not kill the host mid-write.
+	signal.Ignore(syscall.SIGPIPE)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
 	requests := make(chan *Message)
 	config, err := oyster.ReadConfig()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	caller, err := detectCaller(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !caller.allowedBy(allowedOrigins(config, caller.Browser)) {
+		log.Fatalf("%v: %s %s", ErrNotAllowed, caller.Browser, caller.Origin)
 	}
 	gpg := oyster.NewGpgRepo(config.GpgHome())
 	fs := oyster.NewCryptoFS(rwvfs.OSPerm(config.Home(), 0600, 0700), gpg)
 
+	repo := oyster.NewFormRepo(fs)
+	repo.SetSearchOptions(config.SearchOptions())
+	policy, err := parseOriginPolicy(config.CrossOriginPolicy())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var prompt oyster.PassphraseSource
+	if command := config.PassphraseCommand(); command != "" {
+		prompt = oyster.OptionalPassphrase(fs, oyster.PassphraseCommand(command))
+	} else if program := config.Pinentry(); program != "" {
+		prompt = oyster.OptionalPassphrase(fs, oyster.NewPinentry(program))
+	}
+
 	handler := &RequestHandler{
 		requests: requests,
+		quit:     make(chan struct{}),
 		enc:      NewEncoder(os.Stdout),
-		repo:     oyster.NewFormRepo(fs),
+		repo:     repo,
+		fs:       fs,
+		store:    &oyster.Store{Files: oyster.NewFileRepo(fs), Forms: repo},
+		sessions: oyster.NewSessions(config.SessionTTL()),
+		prompt:   prompt,
+		policy:   policy,
 	}
-	go handler.Run()
+	go readRequests(os.Stdin, requests)
+	go func() {
+		<-signals
+		handler.Shutdown()
+	}()
 
-	readRequests(os.Stdin, requests)
+	handler.Run()
+	handler.sessions.LockAll()
 }
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/proglottis/oyster"
+	"github.com/sourcegraph/rwvfs"
+)
+
+// optionsPage is the origin of requests from the extension's options page.
+var optionsPage = Origin{Options: true}
+
+func setupHandler(t testing.TB) (*RequestHandler, chan<- *Message, *bytes.Buffer) {
+	gpg := oyster.NewGpgRepo("../../testdata/gpghome")
+	fs := oyster.NewCryptoFS(rwvfs.Map(map[string]string{}), gpg)
+	if err := oyster.InitRepo(fs, []string{"test@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	repo := oyster.NewFormRepo(fs)
+	for _, key := range []string{"example.com", "www.example.com", "other.com"} {
+		form := &oyster.Form{
+			Key: key,
+			Fields: []oyster.Field{
+				oyster.Field{Name: "password", Value: "password123"},
+				oyster.Field{Name: "username", Value: "bob"},
+			},
+		}
+		if err := repo.Put(form); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests := make(chan *Message)
+	var buf bytes.Buffer
+	return &RequestHandler{
+		requests: requests,
+		enc:      NewEncoder(&buf),
+		repo:     repo,
+		fs:       fs,
+		store:    &oyster.Store{Files: oyster.NewFileRepo(fs), Forms: repo},
+		sessions: oyster.NewSessions(time.Hour),
+		policy:   PolicyConfirm,
+	}, requests, &buf
+}
+
+func request(id uint64, typ string, data interface{}) *Message {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return &Message{ID: id, Type: typ, Data: buf}
+}
+
+func runRequests(t testing.TB, h *RequestHandler, requests chan<- *Message, buf *bytes.Buffer, reqs ...*Message) []Message {
+	done := make(chan struct{})
+	go func() {
+		h.Run()
+		close(done)
+	}()
+	for _, req := range reqs {
+		requests <- req
+	}
+	close(requests)
+	<-done
+	var responses []Message
+	dec := NewDecoder(buf)
+	for {
+		var response Message
+		if err := dec.Decode(&response); err != nil {
+			if err == io.EOF {
+				return responses
+			}
+			t.Fatal(err)
+		}
+		responses = append(responses, response)
+	}
+}
+
+func TestRequestHandler_v1(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	responses := runRequests(t, h, requests, buf,
+		request(0, "LIST", ListData{Origin: optionsPage}),
+		request(0, "GET", GetData{Origin: optionsPage, Key: "example.com", Passphrase: "password"}),
+		request(0, "BOGUS", nil),
+	)
+	expected := []string{"FORMS", "FORM", "ERROR"}
+	if len(responses) != len(expected) {
+		t.Fatalf("Expected %d responses, got %d", len(expected), len(responses))
+	}
+	for i, response := range responses {
+		if response.Type != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], response.Type)
+		}
+		if response.ID != 0 {
+			t.Errorf("Expected no ID, got %d", response.ID)
+		}
+	}
+	var forms []oyster.Form
+	if err := json.Unmarshal(responses[0].Data, &forms); err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 3 {
+		t.Errorf("Expected 3 forms, got %d", len(forms))
+	}
+}
+
+func TestRequestHandler_v2(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	reqs := []*Message{request(1, "HELLO", HelloData{Version: 3})}
+	keys := map[uint64]string{}
+	for i, key := range []string{"example.com", "www.example.com", "other.com", "missing.com"} {
+		id := uint64(i + 2)
+		keys[id] = key
+		reqs = append(reqs, request(id, "GET", GetData{Origin: optionsPage, Key: key, Passphrase: "password"}))
+	}
+	responses := runRequests(t, h, requests, buf, reqs...)
+
+	if len(responses) != len(reqs) {
+		t.Fatalf("Expected %d responses, got %d", len(reqs), len(responses))
+	}
+	if responses[0].ID != 1 || responses[0].Type != "HELLO" {
+		t.Fatalf("Expected HELLO response to request 1, got %#v", responses[0])
+	}
+	var hello HelloData
+	if err := json.Unmarshal(responses[0].Data, &hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Version != protocolVersion {
+		t.Errorf("Expected version %d, got %d", protocolVersion, hello.Version)
+	}
+	for _, response := range responses[1:] {
+		key, ok := keys[response.ID]
+		if !ok {
+			t.Fatalf("Unexpected response ID %d", response.ID)
+		}
+		delete(keys, response.ID)
+		if key == "missing.com" {
+			if response.Type != "ERROR" {
+				t.Errorf("Expected ERROR for %#v, got %#v", key, response.Type)
+			}
+			continue
+		}
+		var form oyster.Form
+		if err := json.Unmarshal(response.Data, &form); err != nil {
+			t.Fatal(err)
+		}
+		if form.Key != key {
+			t.Errorf("Expected %#v for request %d, got %#v", key, response.ID, form.Key)
+		}
+	}
+}
+
+func TestRequestHandler_pages(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	h.enc.MaxSize = 256
+	responses := runRequests(t, h, requests, buf,
+		request(1, "HELLO", HelloData{Version: 2}),
+		request(7, "LIST", ListData{Origin: optionsPage}),
+	)
+	responses = responses[1:]
+	if len(responses) < 2 {
+		t.Fatalf("Expected several pages, got %d", len(responses))
+	}
+	var keys []string
+	for i, response := range responses {
+		if response.Type != "FORMS" || response.ID != 7 {
+			t.Fatalf("Expected FORMS response to request 7, got %#v", response)
+		}
+		if response.Page != i+1 || response.Pages != len(responses) {
+			t.Errorf("Expected page %d of %d, got %d of %d", i+1, len(responses), response.Page, response.Pages)
+		}
+		var forms []oyster.Form
+		if err := json.Unmarshal(response.Data, &forms); err != nil {
+			t.Fatal(err)
+		}
+		for _, form := range forms {
+			keys = append(keys, form.Key)
+		}
+	}
+	if len(keys) != 3 {
+		t.Errorf("Expected 3 forms across all pages, got %#v", keys)
+	}
+}
+
+func TestRequestHandler_files(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	files := oyster.NewFileRepo(h.fs)
+	for _, key := range []string{"web/example.com", "top"} {
+		w, err := files.Create(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	responses := runRequests(t, h, requests, buf,
+		request(0, "LIST", ListData{Origin: optionsPage}),
+		request(0, "GET", GetData{Origin: optionsPage, Key: "web", Passphrase: "password"}),
+	)
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+	var forms []oyster.Form
+	if err := json.Unmarshal(responses[0].Data, &forms); err != nil {
+		t.Fatal(err)
+	}
+	for _, form := range forms {
+		if form.Key == "web" || form.Key == "." {
+			t.Errorf("Expected only forms, got %#v", form.Key)
+		}
+	}
+	if len(forms) != 3 {
+		t.Errorf("Expected 3 forms, got %d", len(forms))
+	}
+	if responses[1].Type != "ERROR" {
+		t.Errorf("Expected ERROR for a directory of files, got %#v", responses[1].Type)
+	}
+}
+
+func TestRequestHandler_pages_v1(t *testing.T) {
+	h, requests, buf := setupHandler(t)
+	h.enc.MaxSize = 256
+	responses := runRequests(t, h, requests, buf, request(0, "LIST", ListData{Origin: optionsPage}))
+	if len(responses) != 1 || responses[0].Type != "ERROR" {
+		t.Fatalf("Expected a single ERROR response, got %#v", responses)
+	}
+	var message string
+	if err := json.Unmarshal(responses[0].Data, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message != ErrMessageTooLarge.Error() {
+		t.Errorf("Expected %#v, got %#v", ErrMessageTooLarge.Error(), message)
+	}
+}
+
+func handle(t testing.TB, h *RequestHandler, buf *bytes.Buffer, req *Message) Message {
+	h.Handle(req)
+	var response Message
+	if err := NewDecoder(buf).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestRequestHandler_sessions(t *testing.T) {
+	h, _, buf := setupHandler(t)
+	defer h.sessions.LockAll()
+	h.version = 2
+
+	response := handle(t, h, buf, request(1, "UNLOCK", UnlockData{Passphrase: "wrong"}))
+	var errData ErrorData
+	if err := json.Unmarshal(response.Data, &errData); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != "ERROR" || errData.Code != CodeBadPassphrase {
+		t.Fatalf("Expected BAD_PASSPHRASE, got %#v", errData)
+	}
+
+	response = handle(t, h, buf, request(2, "UNLOCK", UnlockData{Passphrase: "password"}))
+	if response.Type != "SESSION" {
+		t.Fatalf("Expected SESSION, got %#v", response.Type)
+	}
+	var session SessionData
+	if err := json.Unmarshal(response.Data, &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || session.TTL != 3600 {
+		t.Errorf("Expected token valid for an hour, got %#v", session)
+	}
+
+	response = handle(t, h, buf, request(3, "GET", GetData{Origin: optionsPage, Key: "example.com", Token: session.Token}))
+	var form oyster.Form
+	if err := json.Unmarshal(response.Data, &form); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != "FORM" || form.Key != "example.com" {
+		t.Errorf("Expected form 'example.com', got %#v", response)
+	}
+
+	response = handle(t, h, buf, request(4, "LOCK", LockData{Token: session.Token}))
+	if response.Type != "OK" {
+		t.Errorf("Expected OK, got %#v", response.Type)
+	}
+
+	response = handle(t, h, buf, request(5, "GET", GetData{Origin: optionsPage, Key: "example.com", Token: session.Token}))
+	if err := json.Unmarshal(response.Data, &errData); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != "ERROR" || errData.Code != CodeInvalidSession || !errData.Retryable {
+		t.Errorf("Expected retryable INVALID_SESSION, got %#v", errData)
+	}
+}
+
+func TestRequestHandler_pinentry(t *testing.T) {
+	h, _, buf := setupHandler(t)
+	defer os.Unsetenv("PINENTRY_PIN")
+	h.version = 2
+	h.prompt = oyster.NewPinentry("../../testdata/pinentry.sh")
+
+	response := handle(t, h, buf, request(1, "HELLO", HelloData{Version: 2}))
+	var hello HelloData
+	if err := json.Unmarshal(response.Data, &hello); err != nil {
+		t.Fatal(err)
+	}
+	if !hello.Pinentry {
+		t.Error("Expected HELLO to report pinentry")
+	}
+
+	os.Setenv("PINENTRY_PIN", "password")
+	response = handle(t, h, buf, request(2, "GET", GetData{Origin: optionsPage, Key: "example.com"}))
+	if response.Type != "FORM" {
+		t.Errorf("Expected FORM, got %#v", response.Type)
+	}
+
+	os.Unsetenv("PINENTRY_PIN")
+	response = handle(t, h, buf, request(3, "GET", GetData{Origin: optionsPage, Key: "example.com"}))
+	var errData ErrorData
+	if err := json.Unmarshal(response.Data, &errData); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != "ERROR" || errData.Code != CodeCancelled {
+		t.Errorf("Expected CANCELLED, got %#v", errData)
+	}
+}
+
+func TestRequestHandler_promptUnlocked(t *testing.T) {
+	h, _, buf := setupHandler(t)
+	defer h.sessions.LockAll()
+	h.version = 2
+	h.prompt = oyster.PassphraseFunc(func() ([]byte, error) {
+		done := make(chan struct{})
+		go func() {
+			h.Handle(request(2, "HELLO", HelloData{Version: 2}))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Expected other requests to be handled while prompting")
+		}
+		return []byte("password"), nil
+	})
+
+	h.Handle(request(1, "UNLOCK", UnlockData{}))
+	dec := NewDecoder(buf)
+	var types []string
+	for i := 0; i < 2; i++ {
+		var response Message
+		if err := dec.Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, response.Type)
+	}
+	if types[0] != "HELLO" || types[1] != "SESSION" {
+		t.Errorf("Expected HELLO during the prompt, then SESSION, got %#v", types)
+	}
+}
+
+func TestReadRequests_bad_frames(t *testing.T) {
+	var in bytes.Buffer
+	enc := NewEncoder(&in)
+	enc.Encode(&Message{ID: 1, Type: "LIST"})
+	in.Write([]byte{0x03, 0x00, 0x00, 0x00, '{', '{', '{'})
+	in.Write([]byte{0x01, 0x00, 0x10, 0x00})
+	in.Write(make([]byte, DefaultMaxSize+1))
+	in.Write([]byte{0xFF, 0xFF, 0xFF, 0x7F})
+	in.Write(make([]byte, 64))
+
+	requests := make(chan *Message)
+	go readRequests(&in, requests)
+	var reqs []*Message
+	for req := range requests {
+		reqs = append(reqs, req)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(reqs))
+	}
+	if reqs[0].err != nil || reqs[0].Type != "LIST" {
+		t.Errorf("Expected a LIST request, got %#v", reqs[0])
+	}
+	if reqs[1].err == nil {
+		t.Error("Expected a JSON error")
+	}
+	if reqs[2].err != ErrMessageTooLarge {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", reqs[2].err)
+	}
+
+	h, handlerRequests, buf := setupHandler(t)
+	responses := runRequests(t, h, handlerRequests, buf, reqs[1:]...)
+	for _, response := range responses {
+		if response.Type != "ERROR" {
+			t.Errorf("Expected ERROR, got %#v", response.Type)
+		}
+	}
+}
+
+func TestRequestHandler_fields(t *testing.T) {
+	h, _, buf := setupHandler(t)
+	h.version = 2
+
+	response := handle(t, h, buf, request(1, "PATCH_FIELDS", PatchData{
+		Key:    "example.com",
+		Fields: []oyster.Field{oyster.Field{Name: "username", Value: "alice"}},
+	}))
+	if response.Type != "OK" {
+		t.Fatalf("Expected OK, got %#v", response)
+	}
+	response = handle(t, h, buf, request(2, "DELETE_FIELD", DeleteFieldData{Key: "example.com", Name: "password"}))
+	if response.Type != "OK" {
+		t.Fatalf("Expected OK, got %#v", response)
+	}
+
+	form, err := h.repo.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Fields) != 1 || form.Fields[0] != (oyster.Field{Name: "username", Value: "alice"}) {
+		t.Errorf("Expected only username 'alice', got %#v", form.Fields)
+	}
+
+	response = handle(t, h, buf, request(3, "DELETE_FIELD", DeleteFieldData{Key: "example.com", Name: "../other.com/password"}))
+	var errData ErrorData
+	if err := json.Unmarshal(response.Data, &errData); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != "ERROR" || errData.Code != CodeBadRequest {
+		t.Errorf("Expected BAD_REQUEST, got %#v", errData)
+	}
+}
+
+func TestRequestHandler_prompt(t *testing.T) {
+	h, _, buf := setupHandler(t)
+	h.version = 2
+	h.prompt = oyster.PassphraseFunc(func() ([]byte, error) {
+		return []byte("password"), nil
+	})
+
+	response := handle(t, h, buf, request(1, "GET", GetData{Key: "example.com", Confirmed: true}))
+	if response.Type != "FORM" {
+		t.Errorf("Expected FORM, got %#v", response.Type)
+	}
+}
@@ -3,10 +3,23 @@ package main
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
+	"sync"
 	"unsafe"
 )
 
+const (
+	// DefaultMaxSize is the largest message Chrome accepts from a native
+	// host. Requests are held to the same limit.
+	DefaultMaxSize = 1024 * 1024
+)
+
+var (
+	ErrMessageTooLarge = errors.New("Message too large")
+)
+
 var nativeEndian binary.ByteOrder
 
 func init() {
@@ -20,19 +33,28 @@ func init() {
 }
 
 type Encoder struct {
-	w io.Writer
+	MaxSize int
+	mu      sync.Mutex
+	w       io.Writer
 }
 
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+	return &Encoder{MaxSize: DefaultMaxSize, w: w}
 }
 
+// Encode writes v as a single message. Nothing is written when the encoded
+// message would be larger than MaxSize.
 func (e *Encoder) Encode(v interface{}) error {
 	buf, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
+	if len(buf) > e.MaxSize {
+		return ErrMessageTooLarge
+	}
 	msgLen := uint32(len(buf))
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if err := binary.Write(e.w, nativeEndian, &msgLen); err != nil {
 		return err
 	}
@@ -43,21 +65,39 @@ func (e *Encoder) Encode(v interface{}) error {
 }
 
 type Decoder struct {
-	r io.Reader
+	MaxSize int
+	r       io.Reader
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: r}
+	return &Decoder{MaxSize: DefaultMaxSize, r: r}
 }
 
+// Decode reads the next message into v. A message larger than MaxSize is
+// skipped without being buffered and ErrMessageTooLarge returned, so that
+// decoding can continue with the following message.
 func (d *Decoder) Decode(v interface{}) error {
 	var msgLen uint32
 	if err := binary.Read(d.r, nativeEndian, &msgLen); err != nil {
 		return err
 	}
+	if int64(msgLen) > int64(d.MaxSize) {
+		if _, err := io.CopyN(ioutil.Discard, d.r, int64(msgLen)); err != nil {
+			return noEOF(err)
+		}
+		return ErrMessageTooLarge
+	}
 	buf := make([]byte, msgLen)
 	if _, err := io.ReadFull(d.r, buf); err != nil {
-		return err
+		return noEOF(err)
 	}
 	return json.Unmarshal(buf, v)
 }
+
+// noEOF reports a stream ending part way through a message as unexpected.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -89,3 +90,39 @@ func trim(b []byte) []byte {
 	}
 	return b
 }
+
+func TestEncoder_too_large(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.MaxSize = 4
+	if err := enc.Encode("hello"); err != ErrMessageTooLarge {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("Expected nothing written, got %d bytes", buf.Len())
+	}
+}
+
+func TestDecoder_too_large(t *testing.T) {
+	buf := bytes.NewBuffer(bytes.Join([][]byte{streamEncoded[3], streamEncoded[5]}, nil))
+	dec := NewDecoder(buf)
+	dec.MaxSize = 5
+	var v interface{}
+	if err := dec.Decode(&v); err != ErrMessageTooLarge {
+		t.Fatalf("Expected ErrMessageTooLarge, got %v", err)
+	}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != true {
+		t.Errorf("Expected to resume with the next message, got %v", v)
+	}
+}
+
+func TestDecoder_truncated(t *testing.T) {
+	dec := NewDecoder(bytes.NewBuffer(streamEncoded[3][:6]))
+	var v interface{}
+	if err := dec.Decode(&v); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
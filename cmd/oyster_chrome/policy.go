@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/proglottis/oyster"
+)
+
+// OriginPolicy decides what happens when a page asks for a form that was
+// not saved for its site.
+type OriginPolicy string
+
+const (
+	PolicyAllow   OriginPolicy = "allow"
+	PolicyConfirm OriginPolicy = "confirm"
+	PolicyDeny    OriginPolicy = "deny"
+)
+
+var (
+	ErrCrossOrigin          = errors.New("Form does not belong to this page")
+	ErrConfirmationRequired = errors.New("Confirmation required to fill a form from another site")
+)
+
+func parseOriginPolicy(s string) (OriginPolicy, error) {
+	switch policy := OriginPolicy(s); policy {
+	case PolicyAllow, PolicyConfirm, PolicyDeny:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Unknown cross origin policy %q", s)
+}
+
+// Origin is the page a request is made for. Options is set instead by the
+// extension's options page, which manages forms without filling any page.
+type Origin struct {
+	URL     string `json:"url,omitempty"`
+	Options bool   `json:"options,omitempty"`
+}
+
+// checkOrigin enforces the policy for filling the page of origin with the
+// form at key. A request without a page URL matches no form.
+func checkOrigin(policy OriginPolicy, repo *oyster.FormRepo, origin Origin, key string, confirmed bool) error {
+	if origin.Options || policy == PolicyAllow {
+		return nil
+	}
+	match := false
+	if origin.URL != "" {
+		var err error
+		if match, err = repo.Matches(origin.URL, key); err != nil {
+			return err
+		}
+	}
+	switch {
+	case match:
+		return nil
+	case policy == PolicyConfirm && confirmed:
+		return nil
+	case policy == PolicyConfirm:
+		return ErrConfirmationRequired
+	}
+	return ErrCrossOrigin
+}
+
+// filterOrigin enforces the policy for listing forms to the page of origin.
+// Forms for other sites are only listed when the policy would let the page
+// be filled with them after confirmation.
+func filterOrigin(policy OriginPolicy, repo *oyster.FormRepo, origin Origin, forms []oyster.Form) ([]oyster.Form, error) {
+	if origin.Options || policy == PolicyAllow {
+		return forms, nil
+	}
+	if origin.URL == "" {
+		return nil, ErrCrossOrigin
+	}
+	if policy == PolicyConfirm {
+		return forms, nil
+	}
+	filtered := make([]oyster.Form, 0, len(forms))
+	for _, form := range forms {
+		match, err := repo.Matches(origin.URL, form.Key)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			filtered = append(filtered, form)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	h, _, _ := setupHandler(t)
+	for _, test := range []struct {
+		policy    OriginPolicy
+		url       string
+		key       string
+		confirmed bool
+		err       error
+	}{
+		{PolicyConfirm, "https://www.example.com/login", "example.com", false, nil},
+		{PolicyConfirm, "", "other.com", false, ErrConfirmationRequired},
+		{PolicyDeny, "", "other.com", false, ErrCrossOrigin},
+		{PolicyConfirm, "https://www.example.com/login", "other.com", false, ErrConfirmationRequired},
+		{PolicyConfirm, "https://www.example.com/login", "other.com", true, nil},
+		{PolicyDeny, "https://www.example.com/login", "other.com", true, ErrCrossOrigin},
+		{PolicyDeny, "https://other.com", "other.com", false, nil},
+		{PolicyAllow, "https://www.example.com/login", "other.com", false, nil},
+	} {
+		err := checkOrigin(test.policy, h.repo, Origin{URL: test.url}, test.key, test.confirmed)
+		if err != test.err {
+			t.Errorf("%s %s %s: expected %v, got %v", test.policy, test.url, test.key, test.err, err)
+		}
+	}
+}
+
+func TestCheckOrigin_options(t *testing.T) {
+	h, _, _ := setupHandler(t)
+	if err := checkOrigin(PolicyDeny, h.repo, optionsPage, "other.com", false); err != nil {
+		t.Error("Expected options page to be allowed, got", err)
+	}
+}
+
+func TestFilterOrigin(t *testing.T) {
+	h, _, _ := setupHandler(t)
+	forms, err := h.repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		policy OriginPolicy
+		origin Origin
+		count  int
+		err    error
+	}{
+		{PolicyDeny, Origin{URL: "https://www.example.com/login"}, 2, nil},
+		{PolicyDeny, Origin{}, 0, ErrCrossOrigin},
+		{PolicyConfirm, Origin{URL: "https://www.example.com/login"}, 3, nil},
+		{PolicyConfirm, Origin{}, 0, ErrCrossOrigin},
+		{PolicyDeny, optionsPage, 3, nil},
+		{PolicyAllow, Origin{}, 3, nil},
+	} {
+		filtered, err := filterOrigin(test.policy, h.repo, test.origin, forms)
+		if err != test.err {
+			t.Errorf("%s %#v: expected %v, got %v", test.policy, test.origin, test.err, err)
+		}
+		if len(filtered) != test.count {
+			t.Errorf("%s %#v: expected %d forms, got %d", test.policy, test.origin, test.count, len(filtered))
+		}
+	}
+}
+
+func TestParseOriginPolicy(t *testing.T) {
+	if policy, err := parseOriginPolicy("deny"); err != nil || policy != PolicyDeny {
+		t.Errorf("Expected deny, got %v %v", policy, err)
+	}
+	if _, err := parseOriginPolicy("sometimes"); err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -3,10 +3,14 @@ package oyster
 import (
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/robfig/config"
 )
 
+const DefaultClipboardTimeout = 45 * time.Second
+
 type Config struct {
 	ini *config.Config
 }
@@ -51,3 +55,123 @@ func (c *Config) GpgHome() string {
 	}
 	return path.Join(configDir(), hiddenPrefix+"gnupg")
 }
+
+func (c *Config) SearchOptions() SearchOptions {
+	opts := DefaultSearchOptions
+	if val, err := c.ini.String("", "schemes"); err == nil {
+		opts.Schemes = strings.Fields(val)
+	}
+	if val, err := c.ini.Bool("", "matchPort"); err == nil {
+		opts.MatchPort = val
+	}
+	return opts
+}
+
+// AllowedOrigins lists the extensions of browser, "chrome" or "firefox",
+// allowed to use the native host. Defaults are used unless the browser's
+// section of the config sets "allowed".
+func (c *Config) AllowedOrigins(browser string, defaults []string) []string {
+	if !c.ini.HasSection(browser) {
+		return defaults
+	}
+	val, err := c.ini.String(browser, "allowed")
+	if err != nil {
+		return defaults
+	}
+	return strings.Fields(val)
+}
+
+// CrossOriginPolicy is how the native host treats a request to fill a page
+// with a form saved for another site: "allow", "confirm" or "deny".
+func (c *Config) CrossOriginPolicy() string {
+	val, err := c.ini.String("", "crossOrigin")
+	if err != nil {
+		return "confirm"
+	}
+	return val
+}
+
+// SessionTTL is how long keys unlocked by the native host stay unlocked.
+func (c *Config) SessionTTL() time.Duration {
+	val, err := c.ini.String("", "sessionTTL")
+	if err != nil {
+		return DefaultSessionTTL
+	}
+	ttl, err := time.ParseDuration(val)
+	if err != nil || ttl <= 0 {
+		return DefaultSessionTTL
+	}
+	return ttl
+}
+
+// ClientsFile holds the bearer tokens of clients allowed to use `oyster
+// serve`. It is kept out of the home directory as that is often synced.
+func (c *Config) ClientsFile() string {
+	val, err := c.ini.String("", "clientsFile")
+	if err == nil {
+		return val
+	}
+	return path.Join(configDir(), hiddenPrefix+"oysterclients")
+}
+
+// PassphraseCommand is a shell command printing the passphrase, used instead
+// of prompting when set by OYSTER_PASSPHRASE_CMD or "passphraseCommand".
+func (c *Config) PassphraseCommand() string {
+	if val := os.Getenv("OYSTER_PASSPHRASE_CMD"); val != "" {
+		return val
+	}
+	val, err := c.ini.String("", "passphraseCommand")
+	if err != nil {
+		return ""
+	}
+	return val
+}
+
+// Pinentry is the pinentry program to ask for the passphrase with, if any.
+func (c *Config) Pinentry() string {
+	val, err := c.ini.String("", "pinentry")
+	if err != nil {
+		return ""
+	}
+	return val
+}
+
+// ClipboardTimeout is how long copied passwords stay on the clipboard, zero
+// leaves them there.
+func (c *Config) ClipboardTimeout() time.Duration {
+	val, err := c.ini.String("", "clipboardTimeout")
+	if err != nil {
+		return DefaultClipboardTimeout
+	}
+	timeout, err := time.ParseDuration(val)
+	if err != nil || timeout < 0 {
+		return DefaultClipboardTimeout
+	}
+	return timeout
+}
+
+// ClipboardRestore puts back what was on the clipboard before copying once
+// the timeout passes, instead of clearing it.
+func (c *Config) ClipboardRestore() bool {
+	val, err := c.ini.Bool("", "clipboardRestore")
+	return err == nil && val
+}
+
+// Clipboard is the clipboard backend to copy with, "auto" by default.
+func (c *Config) Clipboard() string {
+	val, err := c.ini.String("", "clipboard")
+	if err != nil {
+		return "auto"
+	}
+	return val
+}
+
+// ClipboardSelection is "clipboard", the default, or the X11 "primary"
+// selection.
+func (c *Config) ClipboardSelection() string {
+	val, err := c.ini.String("", "selection")
+	if err != nil {
+		return "clipboard"
+	}
+	return val
+}
@@ -2,6 +2,9 @@ package oyster
 
 import (
 	"bufio"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
@@ -11,6 +14,8 @@ import (
 
 	"github.com/sourcegraph/rwvfs"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/elgamal"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 var (
@@ -116,9 +121,11 @@ func (w encryptedWriter) Write(p []byte) (int, error) {
 }
 
 func (w encryptedWriter) Close() error {
-	w.plaintext.Close()
-	w.ciphertext.Close()
-	return nil
+	if err := w.plaintext.Close(); err != nil {
+		w.ciphertext.Close()
+		return err
+	}
+	return w.ciphertext.Close()
 }
 
 func WriteEncrypted(ciphertext io.WriteCloser, el openpgp.EntityList) (io.WriteCloser, error) {
@@ -165,6 +172,7 @@ func (r GpgEntityRepo) PublicKeyRing(ids []string) (openpgp.EntityList, error) {
 type CryptoFS struct {
 	rwvfs.FileSystem
 	entities GpgEntityRepo
+	unlocked openpgp.EntityList
 }
 
 func NewCryptoFS(fs rwvfs.FileSystem, entities GpgEntityRepo) *CryptoFS {
@@ -230,6 +238,9 @@ func (fs CryptoFS) OpenEncrypted(name string, passphrase []byte) (io.ReadCloser,
 		}
 		return nil, err
 	}
+	if fs.unlocked != nil {
+		return ReadEncrypted(ciphertext, fs.unlocked, nil)
+	}
 	ids, err := fs.Identities()
 	if err != nil {
 		return nil, err
@@ -241,6 +252,125 @@ func (fs CryptoFS) OpenEncrypted(name string, passphrase []byte) (io.ReadCloser,
 	return ReadEncrypted(ciphertext, el, passphrase)
 }
 
+// NeedsPassphrase reports whether any of the secret keys is encrypted.
+func (fs CryptoFS) NeedsPassphrase() (bool, error) {
+	ids, err := fs.Identities()
+	if err != nil {
+		return false, err
+	}
+	el, err := fs.entities.SecureKeyRing(ids)
+	if err != nil {
+		return false, err
+	}
+	if len(el) < 1 {
+		return false, ErrNoMatchingKeys
+	}
+	for _, entity := range el {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			return true, nil
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// canDecrypt reports whether files can be decrypted without a passphrase,
+// because the keys are unlocked or not encrypted at all.
+func (fs CryptoFS) canDecrypt() (bool, error) {
+	if fs.unlocked != nil {
+		return true, nil
+	}
+	needed, err := fs.NeedsPassphrase()
+	return !needed, err
+}
+
+// Unlock decrypts the secret keys with passphrase, returning a CryptoFS that
+// decrypts files without a passphrase until it is locked again.
+func (fs CryptoFS) Unlock(passphrase []byte) (*CryptoFS, error) {
+	ids, err := fs.Identities()
+	if err != nil {
+		return nil, err
+	}
+	el, err := fs.entities.SecureKeyRing(ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(el) < 1 {
+		return nil, ErrNoMatchingKeys
+	}
+	for _, entity := range el {
+		if err := decryptPrivateKey(entity.PrivateKey, passphrase); err != nil {
+			wipeEntities(el)
+			return nil, err
+		}
+		for _, subkey := range entity.Subkeys {
+			if err := decryptPrivateKey(subkey.PrivateKey, passphrase); err != nil {
+				wipeEntities(el)
+				return nil, err
+			}
+		}
+	}
+	return &CryptoFS{FileSystem: fs.FileSystem, entities: fs.entities, unlocked: el}, nil
+}
+
+// Lock wipes the secret key material held since Unlock. Decrypting files
+// then needs a passphrase again.
+func (fs *CryptoFS) Lock() {
+	wipeEntities(fs.unlocked)
+	fs.unlocked = nil
+}
+
+func decryptPrivateKey(key *packet.PrivateKey, passphrase []byte) error {
+	if key == nil {
+		return nil
+	}
+	if err := key.Decrypt(passphrase); err != nil {
+		return ErrCannotDecryptKey
+	}
+	return nil
+}
+
+func wipeEntities(el openpgp.EntityList) {
+	for _, entity := range el {
+		wipePrivateKey(entity.PrivateKey)
+		for _, subkey := range entity.Subkeys {
+			wipePrivateKey(subkey.PrivateKey)
+		}
+	}
+}
+
+// wipePrivateKey zeroes the secret parts of a decrypted key so that they do
+// not linger in memory.
+func wipePrivateKey(key *packet.PrivateKey) {
+	if key == nil || key.Encrypted {
+		return
+	}
+	switch priv := key.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		priv.D.SetInt64(0)
+		for _, prime := range priv.Primes {
+			prime.SetInt64(0)
+		}
+		if priv.Precomputed.Dp != nil {
+			priv.Precomputed.Dp.SetInt64(0)
+			priv.Precomputed.Dq.SetInt64(0)
+			priv.Precomputed.Qinv.SetInt64(0)
+		}
+	case *dsa.PrivateKey:
+		priv.X.SetInt64(0)
+	case *ecdsa.PrivateKey:
+		priv.D.SetInt64(0)
+	case *elgamal.PrivateKey:
+		priv.X.SetInt64(0)
+	}
+	key.PrivateKey = nil
+	key.Encrypted = true
+}
+
 func (fs CryptoFS) CreateEncrypted(name string) (io.WriteCloser, error) {
 	ciphertext, err := fs.Create(name)
 	if err != nil {
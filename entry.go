@@ -0,0 +1,196 @@
+package oyster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	PasswordField = "password"
+	NotesField    = "notes"
+	fieldSep      = ":"
+)
+
+// knownFields may be written without a space after the separator, as in
+// "username:bob". Other names need one so that lines such as URLs are not
+// split.
+var knownFields = []string{"username", "user", "login", "email", "url"}
+
+// ParseEntry reads a file in the common pass layout: the first line is the
+// password and each later "name: value" line is another field. A line
+// indented by a space continues the field before it. Other lines are kept,
+// in order, in the notes field.
+func ParseEntry(r io.Reader) (FieldSlice, error) {
+	scanner := bufio.NewScanner(r)
+	fields := make(FieldSlice, 0)
+	if !scanner.Scan() {
+		return fields, scanner.Err()
+	}
+	fields = append(fields, Field{Name: PasswordField, Value: scanner.Text()})
+	var notes []string
+	last, inNotes := -1, false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if last >= 0 && strings.HasPrefix(line, " ") {
+			fields[last].Value += "\n" + line[1:]
+			continue
+		}
+		name, value, ok := parseEntryLine(line)
+		switch {
+		case !ok && inNotes:
+			fields[last].Value += "\n" + line
+		case !ok:
+			notes = append(notes, line)
+			last = -1
+		default:
+			fields = append(fields, Field{Name: name, Value: value})
+			last, inNotes = len(fields)-1, strings.EqualFold(name, NotesField)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(notes) > 0 {
+		fields = appendNotes(fields, strings.Join(notes, "\n"))
+	}
+	return fields, nil
+}
+
+func parseEntryLine(line string) (name, value string, ok bool) {
+	i := strings.Index(line, fieldSep+" ")
+	if j := strings.Index(line, fieldSep); j > 0 && isKnownField(line[:j]) {
+		i = j
+	}
+	if i < 1 || strings.TrimSpace(line[:i]) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+}
+
+// needsIndent reports whether a line of the notes would not be read back as
+// free text.
+func needsIndent(line string) bool {
+	_, _, ok := parseEntryLine(line)
+	return ok || strings.HasPrefix(line, " ")
+}
+
+func isKnownField(name string) bool {
+	for _, known := range knownFields {
+		if strings.EqualFold(name, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendNotes adds lines to the notes field, creating it when missing.
+func appendNotes(fields FieldSlice, lines string) FieldSlice {
+	for i := range fields {
+		if strings.EqualFold(fields[i].Name, NotesField) {
+			fields[i].Value += "\n" + lines
+			return fields
+		}
+	}
+	return append(fields, Field{Name: NotesField, Value: lines})
+}
+
+// WriteEntry writes fields in the layout read by ParseEntry. The password
+// field is written first, the rest in name order and the notes last. Further
+// lines of a value are indented, except free text lines of the notes, which
+// are written as they are so that tools such as pass-otp still find them.
+func WriteEntry(w io.Writer, fields FieldSlice) error {
+	var password string
+	var notes *Field
+	others := make(FieldSlice, 0, len(fields))
+	for i, field := range fields {
+		switch {
+		case field.Name == PasswordField:
+			password = field.Value
+		case strings.EqualFold(field.Name, NotesField):
+			notes = &fields[i]
+		default:
+			others = append(others, field)
+		}
+	}
+	sort.Sort(others)
+	if notes != nil {
+		others = append(others, *notes)
+	}
+	if _, err := fmt.Fprintln(w, password); err != nil {
+		return err
+	}
+	for _, field := range others {
+		lines := strings.Split(field.Value, "\n")
+		for i := 1; i < len(lines); i++ {
+			if !strings.EqualFold(field.Name, NotesField) || needsIndent(lines[i]) {
+				lines[i] = " " + lines[i]
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", field.Name, fieldSep, strings.Join(lines, "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SplitField splits a "key:field" address. The address is treated as a
+// plain key when a file exists under the whole name or it has no field.
+func (r *FileRepo) SplitField(address string) (key, field string) {
+	i := strings.LastIndex(address, fieldSep)
+	if i < 0 {
+		return address, ""
+	}
+	if _, err := r.fs.Stat(address + fileExtension); err == nil {
+		return address, ""
+	}
+	return address[:i], address[i+1:]
+}
+
+func (r *FileRepo) Field(key, name string, passphrase []byte) (string, error) {
+	plaintext, err := r.Open(key, passphrase)
+	if err != nil {
+		return "", err
+	}
+	defer plaintext.Close()
+	fields, err := ParseEntry(plaintext)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Form reads a file as a form so that it can be stored in a FormRepo.
+func (r *FileRepo) Form(key string, passphrase []byte) (*Form, error) {
+	plaintext, err := r.Open(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Close()
+	fields, err := ParseEntry(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(fields)
+	return &Form{Key: key, Fields: fields}, nil
+}
+
+// PutForm stores a form, such as one from a FormRepo, as a file.
+func (r *FileRepo) PutForm(form *Form) error {
+	plaintext, err := r.Create(form.Key)
+	if err != nil {
+		return err
+	}
+	if err := WriteEntry(plaintext, form.Fields); err != nil {
+		plaintext.Close()
+		return err
+	}
+	return plaintext.Close()
+}
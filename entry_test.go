@@ -0,0 +1,179 @@
+package oyster
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testEntry = `password123
+username: bob
+url:https://example.com/login
+Some free text
+otpauth://totp/Example:bob?secret=JBSWY3DPEHPK3PXP
+`
+
+func TestParseEntry(t *testing.T) {
+	fields, err := ParseEntry(strings.NewReader(testEntry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FieldSlice{
+		Field{Name: "password", Value: "password123"},
+		Field{Name: "username", Value: "bob"},
+		Field{Name: "url", Value: "https://example.com/login"},
+		Field{Name: "notes", Value: "Some free text\notpauth://totp/Example:bob?secret=JBSWY3DPEHPK3PXP"},
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %d fields, got %d", len(expected), len(fields))
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], fields[i])
+		}
+	}
+}
+
+func TestWriteEntry(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteEntry(&buf, FieldSlice{
+		Field{Name: "username", Value: "bob"},
+		Field{Name: "password", Value: "password123"},
+		Field{Name: "email", Value: "bob@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "password123\nemail: bob@example.com\nusername: bob\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestFileRepoField(t *testing.T) {
+	repo := setupFileRepo(t)
+	w, err := repo.Create("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(testEntry))
+	w.Close()
+
+	for name, expected := range map[string]string{
+		"password": "password123",
+		"username": "bob",
+		"URL":      "https://example.com/login",
+	} {
+		value, err := repo.Field("example.com", name, []byte("password"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Errorf("Expected %#v, got %#v", expected, value)
+		}
+	}
+	if _, err := repo.Field("example.com", "missing", []byte("password")); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	if key, field := repo.SplitField("example.com:username"); key != "example.com" || field != "username" {
+		t.Errorf("Expected 'example.com' and 'username', got %#v and %#v", key, field)
+	}
+	if key, field := repo.SplitField("example.com"); key != "example.com" || field != "" {
+		t.Errorf("Expected 'example.com' and no field, got %#v and %#v", key, field)
+	}
+}
+
+func TestEntryRoundTrip(t *testing.T) {
+	fields, err := ParseEntry(strings.NewReader(testEntry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteEntry(&buf, fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := `password123
+url: https://example.com/login
+username: bob
+notes: Some free text
+otpauth://totp/Example:bob?secret=JBSWY3DPEHPK3PXP
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, buf.String())
+	}
+	assertEntryRoundTrip(t, fields, &buf)
+
+	fields = FieldSlice{
+		Field{Name: "password", Value: "password123"},
+		Field{Name: "address", Value: "1 Main St\nSpringfield"},
+		Field{Name: "notes", Value: "Security questions\nPet: Rex\n  indented\n\nusername:alice"},
+	}
+	buf.Reset()
+	if err := WriteEntry(&buf, fields); err != nil {
+		t.Fatal(err)
+	}
+	expected = `password123
+address: 1 Main St
+ Springfield
+notes: Security questions
+ Pet: Rex
+   indented
+
+ username:alice
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %#v, got %#v", expected, buf.String())
+	}
+	assertEntryRoundTrip(t, fields, &buf)
+}
+
+func assertEntryRoundTrip(t *testing.T, fields FieldSlice, r io.Reader) {
+	reparsed, err := ParseEntry(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(fields)
+	sort.Sort(reparsed)
+	if !reflect.DeepEqual(reparsed, fields) {
+		t.Errorf("Expected %#v, got %#v", fields, reparsed)
+	}
+}
+
+func TestFileRepoFormConversion(t *testing.T) {
+	forms := setupFormRepo(t)
+	files := NewFileRepo(forms.fs)
+	loadTestForms(t, forms)
+
+	form, err := forms.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Key = "converted"
+	if err := files.PutForm(form); err != nil {
+		t.Fatal(err)
+	}
+	line, err := files.Line("converted", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "password123" {
+		t.Errorf("Expected 'password123', got %#v", line)
+	}
+
+	converted, err := files.Form("converted", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, field := range form.Fields {
+		if converted.Fields[i] != field {
+			t.Errorf("Expected %#v, got %#v", field, converted.Fields[i])
+		}
+	}
+	if err := forms.Put(converted); err != nil {
+		t.Fatal(err)
+	}
+}
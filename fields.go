@@ -0,0 +1,100 @@
+package oyster
+
+import (
+	"encoding/json"
+)
+
+const (
+	attrsExtension = ".attrs"
+)
+
+// fieldAttrs describes how a field maps onto a page. Each field's are
+// encrypted in a hidden file of their own, so that forms saved before they
+// existed still read as plain name and value pairs and a field can be
+// patched without decrypting the others.
+type fieldAttrs struct {
+	Type      string `json:"type,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+	Step      int    `json:"step,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+func (f *Field) attrs() fieldAttrs {
+	return fieldAttrs{Type: f.Type, Selector: f.Selector, Step: f.Step, Sensitive: f.Sensitive}
+}
+
+func (f *Field) setAttrs(attrs fieldAttrs) {
+	f.Type = attrs.Type
+	f.Selector = attrs.Selector
+	f.Step = attrs.Step
+	f.Sensitive = attrs.Sensitive
+}
+
+func (r *FormRepo) attrsName(key, name string) string {
+	return r.fs.Join(key, "."+name+attrsExtension+fileExtension)
+}
+
+func (r *FormRepo) readFieldAttrs(key, name string, passphrase []byte) (fieldAttrs, error) {
+	var attrs fieldAttrs
+	f, err := r.fs.OpenEncrypted(r.attrsName(key, name), passphrase)
+	if err != nil {
+		if err == ErrNotFound {
+			return attrs, nil
+		}
+		return attrs, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&attrs)
+	return attrs, err
+}
+
+func (r *FormRepo) writeFieldAttrs(key, name string, attrs fieldAttrs) error {
+	f, err := r.fs.CreateEncrypted(r.attrsName(key, name))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(attrs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// applyFieldAttrs fills in the stored attributes of fields.
+func (r *FormRepo) applyFieldAttrs(key string, fields FieldSlice, passphrase []byte) error {
+	for i := range fields {
+		attrs, err := r.readFieldAttrs(key, fields[i].Name, passphrase)
+		if err != nil {
+			return err
+		}
+		fields[i].setAttrs(attrs)
+	}
+	return nil
+}
+
+// updateFieldAttrs stores the attributes of fields. Fields without any are
+// left as they were unless replace is set, in which case they are cleared.
+func (r *FormRepo) updateFieldAttrs(key string, fields FieldSlice, replace bool) error {
+	for _, field := range fields {
+		switch a := field.attrs(); {
+		case a != (fieldAttrs{}):
+			if err := r.writeFieldAttrs(key, field.Name, a); err != nil {
+				return err
+			}
+		case replace:
+			if err := r.removeFieldAttrs(key, field.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *FormRepo) removeFieldAttrs(key string, names ...string) error {
+	for _, name := range names {
+		if err := r.fs.removeIfExists(r.attrsName(key, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
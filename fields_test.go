@@ -0,0 +1,75 @@
+package oyster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormRepoFieldAttrs(t *testing.T) {
+	repo := setupFormRepo(t)
+	putTestForm(t, repo, "legacy.com")
+	form, err := repo.Get("legacy.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FieldSlice{Field{Name: "password", Value: "password123"}}
+	if !reflect.DeepEqual(form.Fields, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, form.Fields)
+	}
+
+	err = repo.Put(&Form{
+		Key: "example.com",
+		Fields: FieldSlice{
+			Field{Name: "user", Value: "bob", Type: "email", Selector: "#login-email", Step: 1},
+			Field{Name: "pass", Value: "secret", Type: "password", Selector: "#login-pass", Step: 2, Sensitive: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PatchFields("example.com", FieldSlice{Field{Name: "user", Value: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	form, err = repo.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = FieldSlice{
+		Field{Name: "pass", Value: "secret", Type: "password", Selector: "#login-pass", Step: 2, Sensitive: true},
+		Field{Name: "user", Value: "alice", Type: "email", Selector: "#login-email", Step: 1},
+	}
+	if !reflect.DeepEqual(form.Fields, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, form.Fields)
+	}
+
+	form, err = repo.Fields("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Fields[0].Selector != "" || form.Fields[1].Selector != "" {
+		t.Errorf("Expected no attributes without a passphrase, got %#v", form.Fields)
+	}
+
+	if err := repo.RemoveField("example.com", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	form, err = repo.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = FieldSlice{Field{Name: "user", Value: "alice", Type: "email", Selector: "#login-email", Step: 1}}
+	if !reflect.DeepEqual(form.Fields, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, form.Fields)
+	}
+
+	if err := repo.Put(&Form{Key: "example.com", Fields: FieldSlice{Field{Name: "user", Value: "bob"}}}); err != nil {
+		t.Fatal(err)
+	}
+	form, err = repo.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Fields[0].Selector != "" {
+		t.Errorf("Expected Put to replace attributes, got %#v", form.Fields[0])
+	}
+}
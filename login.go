@@ -0,0 +1,68 @@
+package oyster
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	loginPrefix = "@"
+)
+
+// LoginKey returns the key of the named login stored under key, letting a
+// site keep several sets of credentials, e.g. "example.com/@alice".
+func LoginKey(key, login string) string {
+	return strings.Trim(key, pathSep) + pathSep + loginPrefix + login
+}
+
+// SplitLogin splits a login key into the key it is stored under and the
+// login name. The name is empty when key is not a login.
+func SplitLogin(key string) (parent, login string) {
+	key = strings.Trim(key, pathSep)
+	dir, base := path.Split(key)
+	if dir == "" || !strings.HasPrefix(base, loginPrefix) {
+		return key, ""
+	}
+	return strings.Trim(dir, pathSep), base[len(loginPrefix):]
+}
+
+// formLabel names a form for display: the login name of a login, otherwise
+// the key itself.
+func formLabel(key string) string {
+	if _, login := SplitLogin(key); login != "" {
+		return login
+	}
+	return strings.Trim(key, pathSep)
+}
+
+// Logins lists the keys of the logins stored under key.
+func (r *FormRepo) Logins(key string) ([]string, error) {
+	keys := make([]string, 0)
+	fileinfos, err := r.fs.ReadDir(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, err
+	}
+	for _, fileinfo := range fileinfos {
+		name := fileinfo.Name()
+		if !fileinfo.IsDir() || !strings.HasPrefix(name, loginPrefix) {
+			continue
+		}
+		login := LoginKey(key, name[len(loginPrefix):])
+		// Removed forms leave their directory behind.
+		form, err := r.Fields(login)
+		if err != nil {
+			return nil, err
+		}
+		if form.isEmpty() {
+			continue
+		}
+		keys = append(keys, login)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
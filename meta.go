@@ -0,0 +1,189 @@
+package oyster
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	metaExtension     = ".meta"
+	modifiedExtension = ".modified"
+)
+
+type Meta struct {
+	Tags     []string  `json:"tags,omitempty"`
+	URLs     []string  `json:"urls,omitempty"`
+	Notes    string    `json:"notes,omitempty"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+func (m *Meta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// metaNames locates the metadata of an entry and the stamp recording when
+// the entry was last written.
+type metaNames struct {
+	meta     string
+	modified string
+}
+
+// fileMeta names hidden siblings of the password file at key.
+func fileMeta(key string) metaNames {
+	dir, base := path.Split(strings.Trim(key, pathSep))
+	return metaNames{
+		meta:     path.Join(dir, "."+base+metaExtension+fileExtension),
+		modified: path.Join(dir, "."+base+modifiedExtension+fileExtension),
+	}
+}
+
+// formMeta names hidden files inside the form at key, so that a form and a
+// password file with the same key keep their own metadata.
+func formMeta(key string) metaNames {
+	key = strings.Trim(key, pathSep)
+	return metaNames{
+		meta:     path.Join(key, metaExtension+fileExtension),
+		modified: path.Join(key, modifiedExtension+fileExtension),
+	}
+}
+
+func isHidden(name string) bool {
+	base := path.Base(name)
+	return base != "." && strings.HasPrefix(base, ".")
+}
+
+func (fs CryptoFS) readMeta(names metaNames, passphrase []byte) (*Meta, error) {
+	plaintext, err := fs.OpenEncrypted(names.meta, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Close()
+	var meta Meta
+	if err := json.NewDecoder(plaintext).Decode(&meta); err != nil {
+		return nil, err
+	}
+	modified, err := fs.readModified(names, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if modified.After(meta.Modified) {
+		meta.Modified = modified
+	}
+	return &meta, nil
+}
+
+func (fs CryptoFS) readModified(names metaNames, passphrase []byte) (time.Time, error) {
+	plaintext, err := fs.OpenEncrypted(names.modified, passphrase)
+	if err == ErrNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer plaintext.Close()
+	line, err := readline(plaintext)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, line)
+}
+
+// writeMeta stores meta, stamping the modified time and the created time
+// when it has not been set.
+func (fs CryptoFS) writeMeta(names metaNames, meta *Meta) error {
+	now := time.Now().UTC()
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+	meta.Modified = now
+	plaintext, err := fs.CreateEncrypted(names.meta)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(plaintext).Encode(meta); err != nil {
+		plaintext.Close()
+		return err
+	}
+	if err := plaintext.Close(); err != nil {
+		return err
+	}
+	return fs.removeIfExists(names.modified)
+}
+
+// touchMeta records that an entry has been written. Existing metadata is
+// encrypted and cannot be updated without a passphrase, so the time is
+// stamped alongside it instead.
+func (fs CryptoFS) touchMeta(names metaNames) error {
+	_, err := fs.Stat(names.meta)
+	if os.IsNotExist(err) {
+		return fs.writeMeta(names, &Meta{})
+	}
+	if err != nil {
+		return err
+	}
+	plaintext, err := fs.CreateEncrypted(names.modified)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(plaintext, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		plaintext.Close()
+		return err
+	}
+	return plaintext.Close()
+}
+
+func (fs CryptoFS) removeMeta(names metaNames) error {
+	if err := fs.removeIfExists(names.meta); err != nil {
+		return err
+	}
+	return fs.removeIfExists(names.modified)
+}
+
+func (fs CryptoFS) removeIfExists(name string) error {
+	err := fs.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *FileRepo) Meta(key string, passphrase []byte) (*Meta, error) {
+	return r.fs.readMeta(fileMeta(key), passphrase)
+}
+
+func (r *FileRepo) SetMeta(key string, meta *Meta) error {
+	return r.fs.writeMeta(fileMeta(key), meta)
+}
+
+func (r *FormRepo) Meta(key string, passphrase []byte) (*Meta, error) {
+	return r.fs.readMeta(formMeta(key), passphrase)
+}
+
+func (r *FormRepo) SetMeta(key string, meta *Meta) error {
+	return r.fs.writeMeta(formMeta(key), meta)
+}
+
+// LoadMeta decrypts and attaches metadata to each form that has any.
+func (r *FormRepo) LoadMeta(forms []Form, passphrase []byte) error {
+	for i := range forms {
+		meta, err := r.Meta(forms[i].Key, passphrase)
+		switch err {
+		case ErrNotFound: // Ignore
+		case nil:
+			forms[i].Meta = meta
+		default:
+			return err
+		}
+	}
+	return nil
+}
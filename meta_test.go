@@ -0,0 +1,151 @@
+package oyster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileRepoMeta(t *testing.T) {
+	repo := setupFileRepo(t)
+
+	w, err := repo.Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	meta, err := repo.Meta("test", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Created.IsZero() || meta.Modified.IsZero() {
+		t.Errorf("Expected timestamps to be set, got %#v", meta)
+	}
+	created := meta.Created
+
+	meta.Tags = []string{"work"}
+	meta.Notes = "Shared with the team"
+	if err := repo.SetMeta("test", meta); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = repo.Meta("test", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.HasTag("Work") {
+		t.Errorf("Expected tag 'work', got %#v", meta.Tags)
+	}
+	if meta.Notes != "Shared with the team" {
+		t.Errorf("Expected notes, got %#v", meta.Notes)
+	}
+	if !meta.Created.Equal(created) {
+		t.Errorf("Expected created %v, got %v", created, meta.Created)
+	}
+
+	modified := meta.Modified
+	time.Sleep(time.Millisecond)
+	w, err = repo.Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	meta, err = repo.Meta("test", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Modified.After(modified) || !meta.HasTag("work") {
+		t.Errorf("Expected writing to update only the modified time, got %#v", meta)
+	}
+
+	var keys []string
+	if err := repo.Walk(func(key string) { keys = append(keys, key) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "test" {
+		t.Errorf("Expected only 'test', got %#v", keys)
+	}
+
+	if err := repo.Remove("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Meta("test", []byte("password")); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestFormRepoMeta(t *testing.T) {
+	repo := setupFormRepo(t)
+	loadTestForms(t, repo)
+
+	form := &Form{
+		Key:    "example.com/foo",
+		Fields: []Field{Field{Name: "password", Value: "password123"}},
+		Meta:   &Meta{Tags: []string{"personal"}, URLs: []string{"https://example.com/foo/login"}},
+	}
+	if err := repo.Put(form); err != nil {
+		t.Fatal(err)
+	}
+
+	readform, err := repo.Get("example.com/foo", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readform.Meta == nil || !readform.Meta.HasTag("personal") {
+		t.Fatalf("Expected tag 'personal', got %#v", readform.Meta)
+	}
+	if len(readform.Fields) != 2 {
+		t.Errorf("Expected metadata to be hidden from fields, got %#v", readform.Fields)
+	}
+
+	forms, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != len(testKeys) {
+		t.Fatalf("Expected %d forms, got %d", len(testKeys), len(forms))
+	}
+	if err := repo.LoadMeta(forms, []byte("password")); err != nil {
+		t.Fatal(err)
+	}
+	for _, form := range forms {
+		if form.Meta == nil {
+			t.Errorf("Expected metadata for %#v", form.Key)
+		}
+	}
+}
+
+func TestFormRepoMeta_modified(t *testing.T) {
+	fs := setupCryptoFS(t)
+	forms := NewFormRepo(fs)
+	files := NewFileRepo(fs)
+	putTestForm(t, forms, "example.com")
+	if err := forms.SetMeta("example.com", &Meta{Tags: []string{"form"}}); err != nil {
+		t.Fatal(err)
+	}
+	w, err := files.Create("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	meta, err := forms.Meta("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.HasTag("form") {
+		t.Errorf("Expected the form to keep its own metadata, got %#v", meta)
+	}
+	modified := meta.Modified
+
+	time.Sleep(time.Millisecond)
+	if err := forms.PatchFields("example.com", []Field{Field{Name: "username", Value: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = forms.Meta("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Modified.After(modified) || !meta.HasTag("form") {
+		t.Errorf("Expected patching to update the modified time, got %#v", meta)
+	}
+}
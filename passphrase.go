@@ -0,0 +1,93 @@
+package oyster
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// PassphraseSource supplies the passphrase of the secret keys, from a
+// terminal prompt or, so that commands can run unattended, from elsewhere.
+type PassphraseSource interface {
+	Passphrase() ([]byte, error)
+}
+
+// PassphraseFunc adapts a function, such as a prompt, to a PassphraseSource.
+type PassphraseFunc func() ([]byte, error)
+
+func (f PassphraseFunc) Passphrase() ([]byte, error) {
+	return f()
+}
+
+type readerPassphrase struct {
+	once       sync.Once
+	r          io.Reader
+	passphrase []byte
+	err        error
+}
+
+// PassphraseReader reads the passphrase from the first line of r, such as a
+// file descriptor. r is only read once.
+func PassphraseReader(r io.Reader) PassphraseSource {
+	return &readerPassphrase{r: r}
+}
+
+func (s *readerPassphrase) Passphrase() ([]byte, error) {
+	s.once.Do(func() {
+		s.passphrase, s.err = readPassphrase(s.r)
+	})
+	return s.passphrase, s.err
+}
+
+// PassphraseFile reads the passphrase from the first line of a file.
+func PassphraseFile(name string) PassphraseSource {
+	return PassphraseFunc(func() ([]byte, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readPassphrase(f)
+	})
+}
+
+// PassphraseCommand runs command with the shell and reads the passphrase
+// from the first line of its output.
+func PassphraseCommand(command string) PassphraseSource {
+	return PassphraseFunc(func() ([]byte, error) {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("Passphrase command failed: %s", err)
+		}
+		return readPassphrase(bytes.NewReader(out))
+	})
+}
+
+// OptionalPassphrase only asks source when the secret keys of fs are
+// encrypted, so that unencrypted keys never prompt.
+func OptionalPassphrase(fs *CryptoFS, source PassphraseSource) PassphraseSource {
+	return PassphraseFunc(func() ([]byte, error) {
+		needed, err := fs.NeedsPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if !needed {
+			return []byte{}, nil
+		}
+		return source.Passphrase()
+	})
+}
+
+func readPassphrase(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
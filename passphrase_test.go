@@ -0,0 +1,138 @@
+package oyster
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/rwvfs"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var errPrompted = PassphraseFunc(func() ([]byte, error) {
+	return nil, ErrCannotDecryptKey
+})
+
+func TestPassphraseReader(t *testing.T) {
+	source := PassphraseReader(strings.NewReader("password\nignored\n"))
+	for i := 0; i < 2; i++ {
+		passphrase, err := source.Passphrase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(passphrase) != "password" {
+			t.Errorf("Expected %#v, got %#v", "password", string(passphrase))
+		}
+	}
+}
+
+func TestPassphraseFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "oyster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("password\r\n")
+	f.Close()
+
+	passphrase, err := PassphraseFile(f.Name()).Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "password" {
+		t.Errorf("Expected %#v, got %#v", "password", string(passphrase))
+	}
+	if _, err := PassphraseFile(f.Name() + ".missing").Passphrase(); !os.IsNotExist(err) {
+		t.Error("Expected not exist error, got", err)
+	}
+}
+
+func TestPassphraseCommand(t *testing.T) {
+	passphrase, err := PassphraseCommand("echo password").Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "password" {
+		t.Errorf("Expected %#v, got %#v", "password", string(passphrase))
+	}
+	if _, err := PassphraseCommand("exit 1").Passphrase(); err == nil {
+		t.Error("Expected failing command to error")
+	}
+}
+
+func TestOptionalPassphrase(t *testing.T) {
+	fs := setupCryptoFS(t)
+	passphrase, err := OptionalPassphrase(fs, PassphraseFunc(func() ([]byte, error) {
+		return []byte("password"), nil
+	})).Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "password" {
+		t.Errorf("Expected encrypted keys to ask for the passphrase, got %#v", string(passphrase))
+	}
+}
+
+func TestOptionalPassphraseUnencrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oyster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	entity, err := openpgp.NewEntity("Test", "", "plain@example.com", &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Private serialization signs again, with the preferred hash.
+	secring, err := os.Create(path.Join(dir, "secring.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(secring, nil); err != nil {
+		t.Fatal(err)
+	}
+	secring.Close()
+	pubring, err := os.Create(path.Join(dir, "pubring.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(pubring); err != nil {
+		t.Fatal(err)
+	}
+	pubring.Close()
+
+	fs := NewCryptoFS(rwvfs.Map(map[string]string{}), NewGpgRepo(dir))
+	if err := InitRepo(fs, []string{"plain@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewFileRepo(fs)
+	w, err := repo.Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("secret"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase, err := OptionalPassphrase(fs, errPrompted).Passphrase()
+	if err != nil {
+		t.Fatal("Expected no prompt for unencrypted keys, got", err)
+	}
+	r, err := repo.Open("test", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Expected %#v, got %#v", "secret", string(plaintext))
+	}
+}
@@ -0,0 +1,178 @@
+package oyster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	ErrPinentryCancelled = errors.New("Passphrase entry cancelled")
+)
+
+// Pinentry asks for the passphrase with a pinentry program, such as
+// pinentry-gnome3 or pinentry-curses, speaking the Assuan protocol.
+type Pinentry struct {
+	Program     string
+	Title       string
+	Description string
+	Prompt      string
+}
+
+func NewPinentry(program string) *Pinentry {
+	return &Pinentry{
+		Program:     program,
+		Title:       "Oyster",
+		Description: "Enter the passphrase to unlock your Oyster password store",
+		Prompt:      "Passphrase:",
+	}
+}
+
+func (p *Pinentry) Passphrase() ([]byte, error) {
+	cmd := exec.Command(p.Program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	conn := &assuanConn{r: bufio.NewReader(stdout), w: stdin}
+	if _, err := conn.response(); err != nil {
+		return nil, err
+	}
+	for _, option := range pinentryOptions() {
+		// Pinentries without a use for an option may refuse it.
+		if _, err := conn.call("OPTION " + option); err != nil {
+			if _, ok := err.(*pinentryError); !ok {
+				return nil, err
+			}
+		}
+	}
+	for _, command := range []string{
+		"SETTITLE " + assuanEscape(p.Title),
+		"SETDESC " + assuanEscape(p.Description),
+		"SETPROMPT " + assuanEscape(p.Prompt),
+	} {
+		if _, err := conn.call(command); err != nil {
+			return nil, err
+		}
+	}
+	pin, err := conn.call("GETPIN")
+	if err != nil {
+		return nil, err
+	}
+	conn.call("BYE")
+	return pin, nil
+}
+
+// pinentryOptions tells pinentry about the terminal and display the way
+// gpg-agent does, so that a curses pinentry finds the terminal and a
+// graphical one the display.
+func pinentryOptions() []string {
+	var options []string
+	for _, option := range []struct {
+		name string
+		envs []string
+	}{
+		{"ttyname", []string{"GPG_TTY"}},
+		{"ttytype", []string{"TERM"}},
+		{"lc-ctype", []string{"LC_ALL", "LC_CTYPE", "LANG"}},
+		{"lc-messages", []string{"LC_ALL", "LC_MESSAGES", "LANG"}},
+		{"display", []string{"DISPLAY"}},
+	} {
+		for _, env := range option.envs {
+			if value := os.Getenv(env); value != "" {
+				options = append(options, option.name+"="+assuanEscape(value))
+				break
+			}
+		}
+	}
+	return options
+}
+
+// assuanConn is the client side of an Assuan connection.
+type assuanConn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (c *assuanConn) call(command string) ([]byte, error) {
+	if _, err := io.WriteString(c.w, command+"\n"); err != nil {
+		return nil, err
+	}
+	return c.response()
+}
+
+// response reads lines until OK or ERR, returning any data lines.
+func (c *assuanConn) response() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, assuanError(line[len("ERR "):])
+		case strings.HasPrefix(line, "D "):
+			d, err := assuanUnescape(line[len("D "):])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, d...)
+		}
+		// Status "S" and comment "#" lines are ignored.
+	}
+}
+
+// pinentryError is an error reported by pinentry itself.
+type pinentryError struct {
+	message string
+}
+
+func (e *pinentryError) Error() string {
+	return fmt.Sprintf("Pinentry failed: %s", e.message)
+}
+
+// assuanError maps pinentry's error codes, the cancelled and timed out
+// codes are both reported as cancelled.
+func assuanError(message string) error {
+	fields := strings.SplitN(message, " ", 2)
+	switch fields[0] {
+	case "83886179", "83886142":
+		return ErrPinentryCancelled
+	}
+	if len(fields) > 1 {
+		return &pinentryError{fields[1]}
+	}
+	return &pinentryError{message}
+}
+
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func assuanUnescape(s string) ([]byte, error) {
+	// Data lines are percent-encoded, but '+' is a literal plus.
+	d, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(d), nil
+}
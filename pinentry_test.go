@@ -0,0 +1,77 @@
+package oyster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinentry(t *testing.T) {
+	defer os.Unsetenv("PINENTRY_PIN")
+	os.Setenv("PINENTRY_PIN", "pass%25word%0A")
+	pin, err := NewPinentry("testdata/pinentry.sh").Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pin) != "pass%word\n" {
+		t.Errorf("Expected %#v, got %#v", "pass%word\n", string(pin))
+	}
+
+	os.Unsetenv("PINENTRY_PIN")
+	if _, err := NewPinentry("testdata/pinentry.sh").Passphrase(); err != ErrPinentryCancelled {
+		t.Error("Expected ErrPinentryCancelled, got", err)
+	}
+	if _, err := NewPinentry("testdata/missing").Passphrase(); err == nil {
+		t.Error("Expected missing pinentry to error")
+	}
+}
+
+func TestPinentryOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oyster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "options")
+	env := map[string]string{
+		"PINENTRY_PIN":     "password",
+		"PINENTRY_OPTIONS": log,
+		"GPG_TTY":          "/dev/pts/9",
+		"TERM":             "xterm-256color",
+		"LC_ALL":           "",
+		"LC_CTYPE":         "en_NZ.UTF-8",
+		"LC_MESSAGES":      "",
+		"LANG":             "C",
+		"DISPLAY":          ":1",
+	}
+	for name, value := range env {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+	if _, err := NewPinentry("testdata/pinentry.sh").Passphrase(); err != nil {
+		t.Fatal(err)
+	}
+	options, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ttyname=/dev/pts/9\nttytype=xterm-256color\nlc-ctype=en_NZ.UTF-8\nlc-messages=C\ndisplay=:1\n"
+	if string(options) != expected {
+		t.Errorf("Expected %#v, got %#v", expected, string(options))
+	}
+}
+
+func TestAssuanEscape(t *testing.T) {
+	escaped := assuanEscape("100%\nsure")
+	if escaped != "100%25%0Asure" {
+		t.Errorf("Expected %#v, got %#v", "100%25%0Asure", escaped)
+	}
+	unescaped, err := assuanUnescape(escaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(unescaped) != "100%\nsure" {
+		t.Errorf("Expected %#v, got %#v", "100%\nsure", string(unescaped))
+	}
+}
@@ -4,24 +4,32 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"net"
 	"net/url"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kr/fs"
 	"github.com/sourcegraph/rwvfs"
+	"golang.org/x/net/publicsuffix"
 )
 
 const (
-	idFilename    = ".gpg-id"
-	fileExtension = ".gpg"
-	hostSep       = "."
-	pathSep       = "/"
+	idFilename      = ".gpg-id"
+	domainsFilename = ".domains" + fileExtension
+	fileExtension   = ".gpg"
+	hostSep         = "."
+	portSep         = ":"
+	pathSep         = "/"
 )
 
 var (
-	ErrNotFound = errors.New("Not found")
+	ErrNotFound     = errors.New("Not found")
+	ErrInvalidField = errors.New("Invalid field name")
 )
 
 func InitRepo(fs *CryptoFS, ids []string) error {
@@ -35,8 +43,15 @@ func InitRepo(fs *CryptoFS, ids []string) error {
 }
 
 type Form struct {
-	Key    string     `json:"key"`
-	Fields FieldSlice `json:"fields,omitempty"`
+	Key         string          `json:"key"`
+	Label       string          `json:"label,omitempty"`
+	Fields      FieldSlice      `json:"fields,omitempty"`
+	Attachments AttachmentSlice `json:"attachments,omitempty"`
+	Meta        *Meta           `json:"meta,omitempty"`
+}
+
+func (f *Form) isEmpty() bool {
+	return len(f.Fields) < 1 && len(f.Attachments) < 1
 }
 
 type FieldSlice []Field
@@ -48,14 +63,51 @@ func (p FieldSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 type Field struct {
 	Name  string `json:"name"`
 	Value string `json:"value,omitempty"`
+	// Type is the input type, such as "password" or "email", and Selector
+	// finds the input on a page when Name is not enough. Step is the page of
+	// a multi-step login the field belongs to, counting from 1.
+	Type      string `json:"type,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+	Step      int    `json:"step,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+type SearchOptions struct {
+	// Schemes lists the URL schemes that Search will match. Any scheme
+	// matches when empty.
+	Schemes []string
+	// MatchPort makes Search also probe keys of the form "host:port".
+	MatchPort bool
+}
+
+var DefaultSearchOptions = SearchOptions{
+	Schemes: []string{"http", "https"},
+}
+
+func (o SearchOptions) allowsScheme(scheme string) bool {
+	if scheme == "" || len(o.Schemes) < 1 {
+		return true
+	}
+	for _, s := range o.Schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
 }
 
 type FormRepo struct {
-	fs *CryptoFS
+	fs      *CryptoFS
+	opts    SearchOptions
+	domains *domainCache
 }
 
 func NewFormRepo(fs *CryptoFS) *FormRepo {
-	return &FormRepo{fs: fs}
+	return &FormRepo{fs: fs, opts: DefaultSearchOptions, domains: newDomainCache()}
+}
+
+func (r *FormRepo) SetSearchOptions(opts SearchOptions) {
+	r.opts = opts
 }
 
 func (r *FormRepo) List() ([]Form, error) {
@@ -68,11 +120,15 @@ func (r *FormRepo) List() ([]Form, error) {
 		if !walker.Stat().IsDir() {
 			continue
 		}
+		if isHidden(walker.Path()) {
+			walker.SkipDir()
+			continue
+		}
 		form, err := r.Fields(walker.Path())
 		switch err {
 		case ErrNotFound: // Ignore
 		case nil:
-			if len(form.Fields) > 0 {
+			if !form.isEmpty() {
 				forms = append(forms, *form)
 			}
 		default:
@@ -83,26 +139,22 @@ func (r *FormRepo) List() ([]Form, error) {
 }
 
 func (r *FormRepo) Search(query string) ([]Form, error) {
-	url, err := url.Parse(query)
+	keys, err := r.searchKeys(query)
 	if err != nil {
 		return nil, err
 	}
-	components := strings.Split(strings.Trim(url.Path, pathSep), pathSep)
-	if components[0] == "" {
-		components = components[1:]
-	}
-	domains := strings.Split(strings.Trim(url.Host, hostSep), hostSep)
 	forms := make([]Form, 0, 8)
-	for i := 0; i < len(components)+1; i++ {
-		path := strings.Join(components[:len(components)-i], pathSep)
-		for j := range domains {
-			host := strings.Join(domains[j:], ".")
-			key := strings.Trim(host+pathSep+path, pathSep)
+	for _, key := range keys {
+		logins, err := r.Logins(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range append([]string{key}, logins...) {
 			form, err := r.Fields(key)
 			switch err {
 			case ErrNotFound: // Ignore
 			case nil:
-				if len(form.Fields) > 0 {
+				if !form.isEmpty() {
 					forms = append(forms, *form)
 				}
 			default:
@@ -113,6 +165,218 @@ func (r *FormRepo) Search(query string) ([]Form, error) {
 	return forms, nil
 }
 
+// Matches reports whether Search for query would consider key. A login
+// matches wherever the key it is stored under does.
+func (r *FormRepo) Matches(query, key string) (bool, error) {
+	keys, err := r.searchKeys(query)
+	if err != nil {
+		return false, err
+	}
+	key, _ = SplitLogin(key)
+	for _, k := range keys {
+		if k == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// searchKeys lists the keys to probe for query, most specific first. A query
+// without a scheme, such as "example.com/login", is taken as a host and path.
+func (r *FormRepo) searchKeys(query string) ([]string, error) {
+	if !strings.Contains(query, "://") {
+		query = "//" + strings.TrimLeft(query, pathSep)
+	}
+	url, err := url.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if !r.opts.allowsScheme(url.Scheme) {
+		return nil, nil
+	}
+	hosts, err := r.searchHosts(url)
+	if err != nil {
+		return nil, err
+	}
+	components := strings.Split(strings.Trim(url.Path, pathSep), pathSep)
+	if components[0] == "" {
+		components = components[1:]
+	}
+	keys := make([]string, 0, (len(components)+1)*len(hosts))
+	for i := 0; i < len(components)+1; i++ {
+		path := strings.Join(components[:len(components)-i], pathSep)
+		for _, host := range hosts {
+			keys = append(keys, strings.Trim(host+pathSep+path, pathSep))
+		}
+	}
+	return keys, nil
+}
+
+// searchHosts lists the host keys to probe for url, most specific first.
+// Subdomains are stripped no further than the registrable domain, followed
+// by any hosts that declare the registrable domain as equivalent. The
+// equivalent domains are encrypted, so a repository whose keys are locked
+// only probes the hosts of url itself.
+func (r *FormRepo) searchHosts(url *url.URL) ([]string, error) {
+	host := strings.ToLower(strings.Trim(url.Hostname(), hostSep))
+	if host == "" {
+		return nil, nil
+	}
+	domain := registrableDomain(host)
+	var hosts []string
+	for {
+		if r.opts.MatchPort && url.Port() != "" {
+			hosts = append(hosts, host+portSep+url.Port())
+		}
+		hosts = append(hosts, host)
+		if host == domain {
+			break
+		}
+		host = host[strings.Index(host, hostSep)+1:]
+	}
+	equivalents, err := r.equivalentHosts(domain)
+	if err != nil {
+		return nil, err
+	}
+	return append(hosts, equivalents...), nil
+}
+
+func (r *FormRepo) equivalentHosts(domain string) ([]string, error) {
+	ok, err := r.fs.canDecrypt()
+	if err != nil || !ok {
+		return nil, err
+	}
+	fileinfos, err := r.fs.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, fileinfo := range fileinfos {
+		if !fileinfo.IsDir() || fileinfo.Name() == domain {
+			continue
+		}
+		domains, err := r.domains.get(r, fileinfo.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range domains {
+			if d == domain {
+				hosts = append(hosts, fileinfo.Name())
+				break
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// domainCache keeps the decrypted declarations of equivalent domains, so
+// that they are only decrypted again once their file changes.
+type domainCache struct {
+	mu      sync.Mutex
+	entries map[string]domainEntry
+}
+
+type domainEntry struct {
+	modTime time.Time
+	size    int64
+	domains []string
+}
+
+func newDomainCache() *domainCache {
+	return &domainCache{entries: make(map[string]domainEntry)}
+}
+
+func (c *domainCache) get(r *FormRepo, key string) ([]string, error) {
+	fileinfo, err := r.fs.Stat(r.fs.Join(key, domainsFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.forget(key)
+			return nil, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(fileinfo.ModTime()) && entry.size == fileinfo.Size() {
+		return entry.domains, nil
+	}
+	domains, err := r.Domains(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = domainEntry{modTime: fileinfo.ModTime(), size: fileinfo.Size(), domains: domains}
+	c.mu.Unlock()
+	return domains, nil
+}
+
+func (c *domainCache) forget(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// Domains lists the domains that key declares as equivalent to itself.
+func (r *FormRepo) Domains(key string, passphrase []byte) ([]string, error) {
+	f, err := r.fs.OpenEncrypted(r.fs.Join(key, domainsFilename), passphrase)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if domain := strings.TrimSpace(scanner.Text()); domain != "" {
+			domains = append(domains, strings.ToLower(domain))
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// SetDomains declares domains whose URLs should also be matched by Search
+// against key. Only host level keys are consulted.
+func (r *FormRepo) SetDomains(key string, domains []string) error {
+	defer r.domains.forget(key)
+	if len(domains) < 1 {
+		err := r.fs.Remove(r.fs.Join(key, domainsFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := rwvfs.MkdirAll(r.fs, key); err != nil {
+		return err
+	}
+	f, err := r.fs.CreateEncrypted(r.fs.Join(key, domainsFilename))
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if _, err := io.WriteString(f, domain+"\n"); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// registrableDomain returns the public suffix of host plus one label. IP
+// addresses and hosts without a known suffix are returned unchanged.
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
 func (r *FormRepo) Get(key string, passphrase []byte) (*Form, error) {
 	fileinfos, err := r.fs.ReadDir(key)
 	if err != nil {
@@ -120,12 +384,13 @@ func (r *FormRepo) Get(key string, passphrase []byte) (*Form, error) {
 	}
 	form := Form{
 		Key:    key,
+		Label:  formLabel(key),
 		Fields: make([]Field, 0, len(fileinfos)),
 	}
 	for _, fileinfo := range fileinfos {
 		var err error
 		filename := fileinfo.Name()
-		if fileinfo.IsDir() || filepath.Ext(filename) != fileExtension {
+		if fileinfo.IsDir() || isHidden(filename) || filepath.Ext(filename) != fileExtension {
 			continue
 		}
 		field := Field{Name: filename[:len(filename)-len(fileExtension)]}
@@ -136,6 +401,17 @@ func (r *FormRepo) Get(key string, passphrase []byte) (*Form, error) {
 		form.Fields = append(form.Fields, field)
 	}
 	sort.Sort(form.Fields)
+	if err := r.applyFieldAttrs(key, form.Fields, passphrase); err != nil {
+		return nil, err
+	}
+	form.Attachments, err = r.Attachments(key)
+	if err != nil {
+		return nil, err
+	}
+	form.Meta, err = r.Meta(key, passphrase)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
 	return &form, nil
 }
 
@@ -148,6 +424,8 @@ func (r *FormRepo) getField(key, name string, passphrase []byte) (string, error)
 	return readline(plaintext)
 }
 
+// Fields lists the fields of key without decrypting anything, so they have
+// neither values nor attributes.
 func (r *FormRepo) Fields(key string) (*Form, error) {
 	fileinfos, err := r.fs.ReadDir(key)
 	if err != nil {
@@ -155,17 +433,22 @@ func (r *FormRepo) Fields(key string) (*Form, error) {
 	}
 	form := Form{
 		Key:    key,
+		Label:  formLabel(key),
 		Fields: make([]Field, 0, len(fileinfos)),
 	}
 	for _, fileinfo := range fileinfos {
 		filename := fileinfo.Name()
-		if fileinfo.IsDir() || filepath.Ext(filename) != fileExtension {
+		if fileinfo.IsDir() || isHidden(filename) || filepath.Ext(filename) != fileExtension {
 			continue
 		}
 		field := Field{Name: filename[:len(filename)-len(fileExtension)]}
 		form.Fields = append(form.Fields, field)
 	}
 	sort.Sort(form.Fields)
+	form.Attachments, err = r.Attachments(key)
+	if err != nil {
+		return nil, err
+	}
 	return &form, nil
 }
 
@@ -178,7 +461,13 @@ func (r *FormRepo) Put(form *Form) error {
 			return err
 		}
 	}
-	return nil
+	if err := r.updateFieldAttrs(form.Key, form.Fields, true); err != nil {
+		return err
+	}
+	if form.Meta != nil {
+		return r.SetMeta(form.Key, form.Meta)
+	}
+	return r.fs.touchMeta(formMeta(form.Key))
 }
 
 func (r *FormRepo) Remove(key string) error {
@@ -188,14 +477,67 @@ func (r *FormRepo) Remove(key string) error {
 	}
 	for _, fileinfo := range fileinfos {
 		filename := fileinfo.Name()
-		if fileinfo.IsDir() || filepath.Ext(filename) != fileExtension {
+		if fileinfo.IsDir() || isHidden(filename) || filepath.Ext(filename) != fileExtension {
 			continue
 		}
 		if err := r.fs.Remove(r.fs.Join(key, filename)); err != nil {
 			return err
 		}
+		if err := r.removeFieldAttrs(key, filename[:len(filename)-len(fileExtension)]); err != nil {
+			return err
+		}
 	}
-	return nil
+	if err := r.removeAttachments(key); err != nil {
+		return err
+	}
+	if err := r.fs.removeMeta(formMeta(key)); err != nil {
+		return err
+	}
+	return r.SetDomains(key, nil)
+}
+
+// PatchFields writes fields into the existing form at key, leaving its other
+// fields untouched.
+func (r *FormRepo) PatchFields(key string, fields []Field) error {
+	if _, err := r.fs.ReadDir(key); err != nil {
+		return ErrNotFound
+	}
+	for _, field := range fields {
+		if !validFieldName(field.Name) {
+			return ErrInvalidField
+		}
+	}
+	for _, field := range fields {
+		if err := r.putField(key, field); err != nil {
+			return err
+		}
+	}
+	if err := r.updateFieldAttrs(key, fields, false); err != nil {
+		return err
+	}
+	return r.fs.touchMeta(formMeta(key))
+}
+
+func (r *FormRepo) RemoveField(key, name string) error {
+	if !validFieldName(name) {
+		return ErrInvalidField
+	}
+	if err := r.fs.Remove(r.fs.Join(key, name+fileExtension)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := r.removeFieldAttrs(key, name); err != nil {
+		return err
+	}
+	return r.fs.touchMeta(formMeta(key))
+}
+
+// validFieldName reports whether name can be stored as a file of its own
+// within a form's directory.
+func validFieldName(name string) bool {
+	return name != "" && name != "." && !isHidden(name) && !strings.ContainsAny(name, pathSep+"\\")
 }
 
 func (r *FormRepo) putField(key string, field Field) error {
@@ -235,11 +577,20 @@ func (r *FileRepo) Create(key string) (io.WriteCloser, error) {
 	if err := rwvfs.MkdirAll(r.fs, filepath.Dir(key)); err != nil {
 		return nil, err
 	}
+	if err := r.fs.touchMeta(fileMeta(key)); err != nil {
+		return nil, err
+	}
 	return r.fs.CreateEncrypted(key + fileExtension)
 }
 
 func (r *FileRepo) Remove(key string) error {
-	return r.fs.Remove(key + fileExtension)
+	if err := r.fs.Remove(key + fileExtension); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return r.fs.removeMeta(fileMeta(key))
 }
 
 func (r *FileRepo) Walk(walkFn func(file string)) error {
@@ -249,6 +600,12 @@ func (r *FileRepo) Walk(walkFn func(file string)) error {
 			return err
 		}
 		path := walker.Path()
+		if isHidden(path) {
+			if walker.Stat().IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
 		if walker.Stat().IsDir() || filepath.Ext(path) != fileExtension {
 			continue
 		}
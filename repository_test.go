@@ -2,6 +2,7 @@ package oyster
 
 import (
 	"io/ioutil"
+	"reflect"
 	"testing"
 
 	"github.com/sourcegraph/rwvfs"
@@ -121,6 +122,145 @@ func TestFormRepoSearch_no_fields(t *testing.T) {
 	}
 }
 
+func TestFormRepoSearch_public_suffix(t *testing.T) {
+	repo := setupFormRepo(t)
+	for _, key := range []string{"co.uk", "example.co.uk", "github.io", "foo.github.io"} {
+		putTestForm(t, repo, key)
+	}
+
+	for query, expected := range map[string][]string{
+		"https://www.example.co.uk/login": []string{"example.co.uk"},
+		"https://foo.github.io":           []string{"foo.github.io"},
+		"https://bar.github.io":           []string{},
+		"http://localhost:8080":           []string{},
+	} {
+		forms, err := repo.Search(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertFormKeys(t, forms, expected)
+	}
+}
+
+func TestFormRepoSearch_options(t *testing.T) {
+	repo := setupFormRepo(t)
+	for _, key := range []string{"example.com", "example.com:8080"} {
+		putTestForm(t, repo, key)
+	}
+
+	forms, err := repo.Search("http://example.com:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{"example.com"})
+
+	forms, err = repo.Search("ftp://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{})
+
+	forms, err = repo.Search("www.example.com/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{"example.com"})
+
+	repo.SetSearchOptions(SearchOptions{Schemes: []string{"ftp"}, MatchPort: true})
+	forms, err = repo.Search("ftp://example.com:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{"example.com:8080", "example.com"})
+}
+
+func TestFormRepoSearch_equivalent_domains(t *testing.T) {
+	repo := setupFormRepo(t)
+	putTestForm(t, repo, "google.com")
+	if err := repo.SetDomains("google.com", []string{"youtube.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	forms, err := repo.Search("https://www.youtube.com/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{})
+
+	unlocked, err := repo.fs.Unlock([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlocked.Lock()
+	forms, err = repo.Unlocked(unlocked).Search("https://www.youtube.com/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{"google.com"})
+
+	if err := repo.SetDomains("google.com", []string{"youtu.be"}); err != nil {
+		t.Fatal(err)
+	}
+	forms, err = repo.Unlocked(unlocked).Search("https://www.youtube.com/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFormKeys(t, forms, []string{})
+
+	if err := rwvfs.MkdirAll(repo.fs, "broken.com"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.fs.Create("broken.com/" + domainsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Unlocked(unlocked).Search("https://youtu.be"); err == nil {
+		t.Error("Expected an error for undecryptable domains")
+	}
+	if err := repo.fs.Remove("broken.com/" + domainsFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Remove("google.com"); err != nil {
+		t.Fatal(err)
+	}
+	domains, err := repo.Domains("google.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) > 0 {
+		t.Errorf("Expected no domains, got %#v", domains)
+	}
+}
+
+func TestFormRepoMatches(t *testing.T) {
+	repo := setupFormRepo(t)
+	for key, expected := range map[string]bool{
+		"example.com":          true,
+		"www.example.com/foo":  true,
+		"/example.com/":        true,
+		"example.com/foo/bar":  false,
+		"other.com":            false,
+		"www.other.com/foo":    false,
+		"login.example.com":    false,
+		"www.example.com:8080": false,
+	} {
+		match, err := repo.Matches("https://www.example.com/foo/", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match != expected {
+			t.Errorf("%s: expected %v, got %v", key, expected, match)
+		}
+	}
+}
+
 func TestFormRepoRemove(t *testing.T) {
 	repo := setupFormRepo(t)
 	loadTestForms(t, repo)
@@ -205,3 +345,135 @@ func loadTestForms(t testing.TB, repo *FormRepo) {
 		}
 	}
 }
+
+func putTestForm(t testing.TB, repo *FormRepo, key string) {
+	form := &Form{
+		Key: key,
+		Fields: []Field{
+			Field{Name: "password", Value: "password123"},
+		},
+	}
+	if err := repo.Put(form); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertFormKeys(t testing.TB, forms []Form, expected []string) {
+	if len(forms) != len(expected) {
+		t.Fatalf("Expected %d forms, got %d", len(expected), len(forms))
+	}
+	for i := range expected {
+		if forms[i].Key != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], forms[i].Key)
+		}
+	}
+}
+
+func TestFormRepoPatchFields(t *testing.T) {
+	repo := setupFormRepo(t)
+	putTestForm(t, repo, "example.com")
+
+	err := repo.PatchFields("example.com", []Field{
+		Field{Name: "username", Value: "alice"},
+		Field{Name: "email", Value: "alice@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := repo.Get("example.com", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for _, field := range form.Fields {
+		values[field.Name] = field.Value
+	}
+	expected := map[string]string{"password": "password123", "username": "alice", "email": "alice@example.com"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, values)
+	}
+
+	if err := repo.PatchFields("missing.com", []Field{Field{Name: "username"}}); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+	for _, name := range []string{"", "../other.com/password", ".meta"} {
+		if err := repo.PatchFields("example.com", []Field{Field{Name: name}}); err != ErrInvalidField {
+			t.Errorf("Expected ErrInvalidField for %#v, got %v", name, err)
+		}
+	}
+}
+
+func TestFormRepoRemoveField(t *testing.T) {
+	repo := setupFormRepo(t)
+	putTestForm(t, repo, "example.com")
+	if err := repo.PatchFields("example.com", []Field{Field{Name: "username", Value: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.RemoveField("example.com", "username"); err != nil {
+		t.Fatal(err)
+	}
+	form, err := repo.Fields("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Fields) != 1 || form.Fields[0].Name != "password" {
+		t.Errorf("Expected only password to remain, got %#v", form.Fields)
+	}
+	if err := repo.RemoveField("example.com", "username"); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+	if err := repo.RemoveField("example.com", "../other.com"); err != ErrInvalidField {
+		t.Error("Expected ErrInvalidField, got", err)
+	}
+}
+
+func TestFormRepoLogins(t *testing.T) {
+	repo := setupFormRepo(t)
+	for _, key := range []string{"example.com", LoginKey("example.com", "alice"), LoginKey("example.com", "bob"), "other.com"} {
+		putTestForm(t, repo, key)
+	}
+
+	forms, err := repo.Search("https://www.example.com/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, form := range forms {
+		labels = append(labels, form.Label)
+	}
+	expected := []string{"example.com", "alice", "bob"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %#v, got %#v", expected, labels)
+	}
+	if forms[1].Key != "example.com/@alice" {
+		t.Errorf("Expected 'example.com/@alice', got %#v", forms[1].Key)
+	}
+
+	ok, err := repo.Matches("https://example.com/", "example.com/@bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("Expected login to match its site")
+	}
+
+	logins, err := repo.Logins("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(logins, []string{"example.com/@alice", "example.com/@bob"}) {
+		t.Errorf("Expected alice and bob, got %#v", logins)
+	}
+	if logins, err := repo.Logins("missing.com"); err != nil || len(logins) != 0 {
+		t.Errorf("Expected no logins, got %#v and %v", logins, err)
+	}
+
+	key, login := SplitLogin("example.com/foo/@alice")
+	if key != "example.com/foo" || login != "alice" {
+		t.Errorf("Expected 'example.com/foo' and 'alice', got %#v and %#v", key, login)
+	}
+	if key, login := SplitLogin("example.com"); key != "example.com" || login != "" {
+		t.Errorf("Expected plain key, got %#v and %#v", key, login)
+	}
+}
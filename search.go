@@ -0,0 +1,133 @@
+package oyster
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	scoreExact     = 1000
+	scoreBase      = 900
+	scorePrefix    = 800
+	scoreWord      = 700
+	scoreSubstring = 600
+	scoreFuzzy     = 500
+)
+
+type Match struct {
+	Key   string `json:"key"`
+	Field string `json:"field,omitempty"`
+	Score int    `json:"score"`
+}
+
+type MatchSlice []Match
+
+func (p MatchSlice) Len() int { return len(p) }
+func (p MatchSlice) Less(i, j int) bool {
+	if p[i].Score != p[j].Score {
+		return p[i].Score > p[j].Score
+	}
+	return p[i].Key < p[j].Key
+}
+func (p MatchSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// FuzzyScore ranks how well term matches s. Exact, prefix and substring
+// matches always rank above a match on characters scattered through s. A
+// score of zero means no match.
+func FuzzyScore(term, s string) int {
+	term = strings.ToLower(term)
+	s = strings.ToLower(s)
+	if term == "" || s == "" {
+		return 0
+	}
+	// Prefer shorter candidates when the match itself is equally good.
+	penalty := len(s) - len(term)
+	if penalty > 99 {
+		penalty = 99
+	}
+	switch i := strings.Index(s, term); {
+	case s == term:
+		return scoreExact
+	case s[strings.LastIndex(s, pathSep)+1:] == term:
+		return scoreBase - penalty
+	case i == 0:
+		return scorePrefix - penalty
+	case i > 0 && isBoundary(s, i):
+		return scoreWord - penalty
+	case i > 0:
+		return scoreSubstring - penalty
+	}
+	score := 0
+	last := -1
+	j := 0
+	for i := 0; i < len(s) && j < len(term); i++ {
+		if s[i] != term[j] {
+			continue
+		}
+		score++
+		if last >= 0 && i == last+1 {
+			score += 5
+		}
+		if isBoundary(s, i) {
+			score += 3
+		}
+		last = i
+		j++
+	}
+	if j < len(term) {
+		return 0
+	}
+	score = score*10 - penalty
+	if score >= scoreFuzzy {
+		score = scoreFuzzy - 1
+	}
+	if score < 1 {
+		score = 1
+	}
+	return score
+}
+
+func isBoundary(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	return strings.IndexByte("/._-@: ", s[i-1]) >= 0
+}
+
+func (r *FileRepo) Find(term string) ([]Match, error) {
+	matches := make(MatchSlice, 0)
+	err := r.Walk(func(key string) {
+		if score := FuzzyScore(term, key); score > 0 {
+			matches = append(matches, Match{Key: key, Score: score})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(matches)
+	return matches, nil
+}
+
+// Find ranks forms by their key and field names. A match on a field name
+// ranks below any match on a key.
+func (r *FormRepo) Find(term string) ([]Match, error) {
+	forms, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	matches := make(MatchSlice, 0)
+	for _, form := range forms {
+		match := Match{Key: form.Key, Score: FuzzyScore(term, form.Key)}
+		for _, field := range form.Fields {
+			if score := FuzzyScore(term, field.Name) / 2; score > match.Score {
+				match.Field = field.Name
+				match.Score = score
+			}
+		}
+		if match.Score > 0 {
+			matches = append(matches, match)
+		}
+	}
+	sort.Sort(matches)
+	return matches, nil
+}
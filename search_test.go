@@ -0,0 +1,89 @@
+package oyster
+
+import (
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	ranked := []string{
+		"github",
+		"web/github",
+		"github.com",
+		"work/github.com",
+		"work/git-hub",
+		"gxixtxhxuxb",
+	}
+	last := FuzzyScore("github", ranked[0])
+	for _, s := range ranked[1:] {
+		score := FuzzyScore("github", s)
+		if score <= 0 {
+			t.Errorf("Expected %#v to match", s)
+		}
+		if score >= last {
+			t.Errorf("Expected %#v to rank below the previous candidate, got %d >= %d", s, score, last)
+		}
+		last = score
+	}
+	if score := FuzzyScore("github", "gitlab.com"); score != 0 {
+		t.Errorf("Expected no match, got %d", score)
+	}
+	if score := FuzzyScore("GitHub", "github.com"); score <= 0 {
+		t.Error("Expected case insensitive match")
+	}
+}
+
+func TestFileRepoFind(t *testing.T) {
+	repo := setupFileRepo(t)
+	for _, key := range []string{"mail/example", "web/example.com", "web/other.com"} {
+		w, err := repo.Create(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	matches, err := repo.Find("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"mail/example", "web/example.com"}
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d matches, got %d", len(expected), len(matches))
+	}
+	for i := range expected {
+		if matches[i].Key != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], matches[i].Key)
+		}
+	}
+}
+
+func TestFormRepoFind(t *testing.T) {
+	repo := setupFormRepo(t)
+	loadTestForms(t, repo)
+	repo.Put(&Form{
+		Key:    "other.com",
+		Fields: []Field{Field{Name: "email", Value: "bob@example.com"}},
+	})
+
+	matches, err := repo.Find("www.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 4 {
+		t.Fatalf("Expected 4 matches, got %d", len(matches))
+	}
+	if matches[0].Key != "www.example.com" {
+		t.Errorf("Expected 'www.example.com', got %#v", matches[0].Key)
+	}
+
+	matches, err = repo.Find("email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(matches))
+	}
+	if matches[0].Key != "other.com" || matches[0].Field != "email" {
+		t.Errorf("Expected field match on 'other.com', got %#v", matches[0])
+	}
+}
@@ -0,0 +1,121 @@
+package oyster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSessionTTL = 5 * time.Minute
+	tokenSize         = 32
+)
+
+var (
+	ErrInvalidSession = errors.New("Invalid or expired session")
+)
+
+type session struct {
+	mu sync.RWMutex
+	fs *CryptoFS
+}
+
+// lock wipes the unlocked keys once no request is using them.
+func (s *session) lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fs != nil {
+		s.fs.Lock()
+		s.fs = nil
+	}
+}
+
+// Sessions holds unlocked keys by opaque token so that the passphrase is
+// only needed once. Each session is locked when its TTL runs out.
+type Sessions struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*session
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[string]*session)}
+}
+
+func (s *Sessions) TTL() time.Duration {
+	return s.ttl
+}
+
+// Unlock decrypts the keys of fs with passphrase and returns the token of a
+// new session holding them.
+func (s *Sessions) Unlock(fs *CryptoFS, passphrase []byte) (string, error) {
+	unlocked, err := fs.Unlock(passphrase)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, tokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		unlocked.Lock()
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	s.mu.Lock()
+	s.sessions[token] = &session{fs: unlocked}
+	s.mu.Unlock()
+	time.AfterFunc(s.ttl, func() {
+		s.Lock(token)
+	})
+	return token, nil
+}
+
+// Use calls fn with the unlocked CryptoFS of token. The session cannot be
+// locked until fn returns.
+func (s *Sessions) Use(token string, fn func(fs *CryptoFS) error) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[token]
+	s.mu.Unlock()
+	if !ok {
+		return ErrInvalidSession
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	if sess.fs == nil {
+		return ErrInvalidSession
+	}
+	return fn(sess.fs)
+}
+
+// Lock ends the session of token, wiping its key material.
+func (s *Sessions) Lock(token string) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[token]
+	delete(s.sessions, token)
+	s.mu.Unlock()
+	if !ok {
+		return ErrInvalidSession
+	}
+	sess.lock()
+	return nil
+}
+
+func (s *Sessions) LockAll() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.lock()
+	}
+}
+
+// Unlocked returns a copy of the repo that reads with the keys of fs, which
+// is usually the CryptoFS of a session.
+func (r *FormRepo) Unlocked(fs *CryptoFS) *FormRepo {
+	return &FormRepo{fs: fs, opts: r.opts, domains: r.domains}
+}
+
+func (r *FileRepo) Unlocked(fs *CryptoFS) *FileRepo {
+	return &FileRepo{fs: fs}
+}
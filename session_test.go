@@ -0,0 +1,101 @@
+package oyster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/rwvfs"
+)
+
+func setupCryptoFS(t testing.TB) *CryptoFS {
+	gpg := NewGpgRepo("testdata/gpghome")
+	fs := NewCryptoFS(rwvfs.Map(map[string]string{}), gpg)
+	if err := InitRepo(fs, []string{"test@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestCryptoFSUnlock(t *testing.T) {
+	fs := setupCryptoFS(t)
+	repo := NewFormRepo(fs)
+	putTestForm(t, repo, "example.com")
+
+	if _, err := fs.Unlock([]byte("wrong")); err != ErrCannotDecryptKey {
+		t.Fatal("Expected ErrCannotDecryptKey, got", err)
+	}
+	unlocked, err := fs.Unlock([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := repo.Unlocked(unlocked).Get("example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Key != "example.com" {
+		t.Errorf("Expected 'example.com', got %#v", form.Key)
+	}
+
+	unlocked.Lock()
+	if _, err := repo.Unlocked(unlocked).Get("example.com", nil); err == nil {
+		t.Error("Expected error after lock")
+	}
+	if _, err := repo.Get("example.com", []byte("password")); err != nil {
+		t.Error("Expected original keys to be untouched, got", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	fs := setupCryptoFS(t)
+	sessions := NewSessions(time.Hour)
+	defer sessions.LockAll()
+
+	if _, err := sessions.Unlock(fs, []byte("wrong")); err != ErrCannotDecryptKey {
+		t.Fatal("Expected ErrCannotDecryptKey, got", err)
+	}
+	token, err := sessions.Unlock(fs, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sessions.Unlock(fs, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == other {
+		t.Error("Expected unique tokens")
+	}
+
+	called := false
+	err = sessions.Use(token, func(fs *CryptoFS) error {
+		called = fs != nil
+		return nil
+	})
+	if err != nil || !called {
+		t.Fatal("Expected session to be usable, got", err)
+	}
+	if err := sessions.Lock(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Use(token, func(*CryptoFS) error { return nil }); err != ErrInvalidSession {
+		t.Error("Expected ErrInvalidSession, got", err)
+	}
+	if err := sessions.Lock(token); err != ErrInvalidSession {
+		t.Error("Expected ErrInvalidSession, got", err)
+	}
+	if err := sessions.Use(other, func(*CryptoFS) error { return nil }); err != nil {
+		t.Error("Expected other session to remain, got", err)
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	fs := setupCryptoFS(t)
+	sessions := NewSessions(10 * time.Millisecond)
+	token, err := sessions.Unlock(fs, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := sessions.Use(token, func(*CryptoFS) error { return nil }); err != ErrInvalidSession {
+		t.Error("Expected ErrInvalidSession, got", err)
+	}
+}
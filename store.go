@@ -0,0 +1,178 @@
+package oyster
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Entry is a key in a Store, either a password file or a form.
+type Entry struct {
+	Key  string `json:"key"`
+	Form bool   `json:"form,omitempty"`
+}
+
+type EntrySlice []Entry
+
+func (p EntrySlice) Len() int           { return len(p) }
+func (p EntrySlice) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p EntrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (p EntrySlice) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for _, entry := range p {
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+// Store is a single view of the password files used by the command line
+// and the forms saved by the browser, which share one repository.
+type Store struct {
+	Files *FileRepo
+	Forms *FormRepo
+}
+
+func NewStore(fs *CryptoFS) *Store {
+	return &Store{Files: NewFileRepo(fs), Forms: NewFormRepo(fs)}
+}
+
+// IsFile reports whether key is a password file rather than a form.
+func (s *Store) IsFile(key string) bool {
+	return s.exists(key + fileExtension)
+}
+
+// IsForm reports whether key is a form rather than a directory of password
+// files. Only forms have form metadata or field attributes; a directory
+// without either, such as one of password files from before metadata was
+// kept, is taken to hold password files.
+func (s *Store) IsForm(key string) bool {
+	key = strings.Trim(key, pathSep)
+	if key == "" || key == "." {
+		return false
+	}
+	if names := formMeta(key); s.exists(names.meta) || s.exists(names.modified) {
+		return true
+	}
+	fileinfos, err := s.Files.fs.ReadDir(key)
+	if err != nil {
+		return false
+	}
+	for _, fileinfo := range fileinfos {
+		filename := fileinfo.Name()
+		if !fileinfo.IsDir() && isHidden(filename) && strings.HasSuffix(filename, attrsExtension+fileExtension) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) exists(name string) bool {
+	_, err := s.Files.fs.Stat(name)
+	return err == nil
+}
+
+// List lists password files, forms or both. The fields of forms are files
+// too but are never listed as password files.
+func (s *Store) List(files, forms bool) (EntrySlice, error) {
+	entries := make(EntrySlice, 0)
+	formList, err := s.Forms.List()
+	if err != nil {
+		return nil, err
+	}
+	formKeys := make(map[string]bool)
+	for _, form := range formList {
+		if !s.IsForm(form.Key) {
+			continue
+		}
+		formKeys[form.Key] = true
+		if forms {
+			entries = append(entries, Entry{Key: form.Key, Form: true})
+		}
+	}
+	if files {
+		err := s.Files.Walk(func(key string) {
+			if !formKeys[path.Dir(key)] {
+				entries = append(entries, Entry{Key: key})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(entries)
+	return entries, nil
+}
+
+// Get reads key as a form whether it is a password file or a form. A file
+// takes precedence when both exist.
+func (s *Store) Get(key string, passphrase []byte) (*Form, error) {
+	if s.IsFile(key) {
+		return s.Files.Form(key, passphrase)
+	}
+	if !s.IsForm(key) {
+		return nil, ErrNotFound
+	}
+	form, err := s.Forms.Get(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(form.Fields) < 1 {
+		return nil, ErrNotFound
+	}
+	return form, nil
+}
+
+// Meta reads the metadata of entry from the repository it belongs to.
+func (s *Store) Meta(entry Entry, passphrase []byte) (*Meta, error) {
+	if entry.Form {
+		return s.Forms.Meta(entry.Key, passphrase)
+	}
+	return s.Files.Meta(entry.Key, passphrase)
+}
+
+// FilterForms keeps the forms that are not directories of password files.
+func (s *Store) FilterForms(forms []Form) []Form {
+	filtered := forms[:0]
+	for _, form := range forms {
+		if s.IsForm(form.Key) {
+			filtered = append(filtered, form)
+		}
+	}
+	return filtered
+}
+
+// Field reads the named field of key, matching the name case-insensitively.
+func (s *Store) Field(key, name string, passphrase []byte) (string, error) {
+	if s.IsFile(key) {
+		return s.Files.Field(key, name, passphrase)
+	}
+	if !s.IsForm(key) {
+		return "", ErrNotFound
+	}
+	return s.Forms.Field(key, name, passphrase)
+}
+
+// SplitField splits a "key:field" address like FileRepo.SplitField, also
+// keeping whole an address that is a form, such as "example.com:8080".
+func (s *Store) SplitField(address string) (key, field string) {
+	if s.IsForm(address) {
+		return address, ""
+	}
+	return s.Files.SplitField(address)
+}
+
+// Field reads the named field of the form at key, matching the name
+// case-insensitively.
+func (r *FormRepo) Field(key, name string, passphrase []byte) (string, error) {
+	form, err := r.Fields(key)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range form.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return r.getField(key, field.Name, passphrase)
+		}
+	}
+	return "", ErrNotFound
+}
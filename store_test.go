@@ -0,0 +1,166 @@
+package oyster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/rwvfs"
+)
+
+func setupStore(t testing.TB) *Store {
+	store := NewStore(setupCryptoFS(t))
+	putTestForm(t, store.Forms, "example.com")
+	w, err := store.Files.Create("email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEntry(w, FieldSlice{
+		Field{Name: PasswordField, Value: "hunter2"},
+		Field{Name: "Username", Value: "bob"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w, err = store.Files.Create("web/example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStoreList(t *testing.T) {
+	store := setupStore(t)
+	entries, err := store.List(true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := EntrySlice{
+		Entry{Key: "email"},
+		Entry{Key: "example.com", Form: true},
+		Entry{Key: "web/example.org"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, entries)
+	}
+	entries, err = store.List(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, expected[1:2]) {
+		t.Errorf("Expected only the form, got %#v", entries)
+	}
+	if store.IsForm("web") || store.IsForm(".") || !store.IsForm("example.com") {
+		t.Error("Expected only example.com to be a form")
+	}
+	forms, err := store.Forms.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forms = store.FilterForms(forms); len(forms) != 1 || forms[0].Key != "example.com" {
+		t.Errorf("Expected only example.com, got %#v", forms)
+	}
+}
+
+func TestStoreList_legacyFiles(t *testing.T) {
+	store := setupStore(t)
+	// Written without metadata, as before metadata was kept.
+	if err := rwvfs.MkdirAll(store.Files.fs, "legacy"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := store.Files.fs.CreateEncrypted("legacy/github" + fileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hunter2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.IsForm("legacy") {
+		t.Error("Expected a directory of password files not to be a form")
+	}
+	entries, err := store.List(true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := EntrySlice{
+		Entry{Key: "email"},
+		Entry{Key: "example.com", Form: true},
+		Entry{Key: "legacy/github"},
+		Entry{Key: "web/example.org"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, entries)
+	}
+	forms, err := store.Forms.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forms = store.FilterForms(forms); len(forms) != 1 || forms[0].Key != "example.com" {
+		t.Errorf("Expected only example.com, got %#v", forms)
+	}
+	if _, err := store.Get("legacy", []byte("password")); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestStoreSplitField(t *testing.T) {
+	store := setupStore(t)
+	putTestForm(t, store.Forms, "example.com:8080")
+	for address, expected := range map[string][2]string{
+		"example.com:8080":          {"example.com:8080", ""},
+		"example.com:8080:password": {"example.com:8080", "password"},
+		"email:username":            {"email", "username"},
+	} {
+		key, field := store.SplitField(address)
+		if key != expected[0] || field != expected[1] {
+			t.Errorf("Expected %#v for %#v, got %#v %#v", expected, address, key, field)
+		}
+	}
+}
+
+func TestStoreField(t *testing.T) {
+	store := setupStore(t)
+	passphrase := []byte("password")
+	for address, expected := range map[string]string{
+		"email:username":       "bob",
+		"email:password":       "hunter2",
+		"example.com:Password": "password123",
+	} {
+		key, field := store.SplitField(address)
+		value, err := store.Field(key, field, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Errorf("Expected %#v for %#v, got %#v", expected, address, value)
+		}
+	}
+	if _, err := store.Field("example.com", "username", passphrase); err != ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	for _, key := range []string{"email", "example.com"} {
+		form, err := store.Get(key, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if form.Key != key || len(form.Fields) < 1 {
+			t.Errorf("Expected fields for %#v, got %#v", key, form)
+		}
+	}
+	for _, key := range []string{"missing", "web"} {
+		if _, err := store.Get(key, passphrase); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for %#v, got %v", key, err)
+		}
+	}
+	if !store.IsFile("email") || store.IsFile("example.com") {
+		t.Error("Expected only email to be a file")
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# A fake pinentry answering GETPIN with $PINENTRY_PIN, cancelling when unset.
+# Options set are appended to $PINENTRY_OPTIONS when it names a file.
+echo "OK Pleased to meet you"
+while read -r command args; do
+	case "$command" in
+	GETPIN)
+		if [ -n "$PINENTRY_PIN" ]; then
+			echo "S PASSWORD_FROM_CACHE"
+			echo "D $PINENTRY_PIN"
+			echo "OK"
+		else
+			echo "ERR 83886179 Operation cancelled <Pinentry>"
+		fi
+		;;
+	OPTION)
+		if [ -n "$PINENTRY_OPTIONS" ]; then
+			echo "$args" >> "$PINENTRY_OPTIONS"
+		fi
+		echo "OK"
+		;;
+	BYE)
+		echo "OK closing connection"
+		exit 0
+		;;
+	*)
+		echo "OK"
+		;;
+	esac
+done